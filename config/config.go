@@ -17,9 +17,31 @@ type AppConfig struct {
 	Port      int    `mapstructure:"port"`
 	Secret    string `mapstructure:"secret"`
 
-	*LogConfig   `mapstructure:"log"`
-	*MySQLConfig `mapstructure:"mysql"`
-	*RedisConfig `mapstructure:"redis"`
+	*ServerConfig   `mapstructure:"server"`
+	*LogConfig      `mapstructure:"log"`
+	*MySQLConfig    `mapstructure:"mysql"`
+	*RedisConfig    `mapstructure:"redis"`
+	*WorkerConfig   `mapstructure:"worker"`
+	*PasswordConfig `mapstructure:"password"`
+	*JWTConfig      `mapstructure:"jwt"`
+	*RankingConfig  `mapstructure:"ranking"`
+
+	*RateLimitConfig  `mapstructure:"rate_limit"`
+	*LoginGuardConfig `mapstructure:"login_guard"`
+}
+
+// ServerConfig HTTP 服务配置，时间单位为秒，未配置的项使用默认值
+type ServerConfig struct {
+	ReadTimeout     int `mapstructure:"read_timeout"`     // 读取整个请求（含请求体）的超时
+	WriteTimeout    int `mapstructure:"write_timeout"`    // 从读完请求头到写完响应的超时
+	IdleTimeout     int `mapstructure:"idle_timeout"`     // keep-alive 连接的空闲超时
+	ShutdownTimeout int `mapstructure:"shutdown_timeout"` // 收到退出信号后等待请求处理完、后台任务退出的最长时间
+
+	// 可信的反向代理的 IP 或 CIDR，只有来自这些地址的请求才会使用 X-Forwarded-For 等请求头中的客户端 IP；
+	// 未配置时不信任任何代理，客户端 IP 取连接的对端地址，避免伪造请求头绕过限流和登录锁定
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// Prometheus 指标的监听地址，与业务端口分开，只应在内网开放，未配置时只监听本机
+	MetricsAddr string `mapstructure:"metrics_addr"`
 }
 
 type MySQLConfig struct {
@@ -41,6 +63,74 @@ type RedisConfig struct {
 	MinIdleConns int    `mapstructure:"min_idle_conns"`
 }
 
+// WorkerConfig 后台任务配置，时间单位均为秒
+type WorkerConfig struct {
+	VoteArchiveInterval  int `mapstructure:"vote_archive_interval"`   // 投票归档任务的执行间隔
+	VoteArchiveBatchSize int `mapstructure:"vote_archive_batch_size"` // 每批归档的帖子数
+	VoteSyncInterval     int `mapstructure:"vote_sync_interval"`      // 投票数据同步到 MySQL 的间隔
+	VoteSyncBatchSize    int `mapstructure:"vote_sync_batch_size"`    // 每批同步的帖子数
+	OutboxRelayInterval  int `mapstructure:"outbox_relay_interval"`   // 处理发件箱事件的间隔
+	OutboxRelayBatchSize int `mapstructure:"outbox_relay_batch_size"` // 每批处理的事件数
+}
+
+// PasswordConfig argon2id 密码哈希参数，调大参数会增加每次哈希的耗时和内存占用
+type PasswordConfig struct {
+	Time    uint32 `mapstructure:"time"`    // 迭代次数
+	Memory  uint32 `mapstructure:"memory"`  // 内存占用（KiB）
+	Threads uint8  `mapstructure:"threads"` // 并行度
+	KeyLen  uint32 `mapstructure:"key_len"` // 哈希结果长度（字节）
+	SaltLen uint32 `mapstructure:"salt_len"`
+}
+
+// JWTConfig token 签发配置，时间单位为秒
+type JWTConfig struct {
+	Issuer       string         `mapstructure:"issuer"`
+	Audience     string         `mapstructure:"audience"`
+	AccessTTL    int            `mapstructure:"access_ttl"`     // access token 有效期
+	RefreshTTL   int            `mapstructure:"refresh_ttl"`    // refresh token 有效期
+	SigningKeyID string         `mapstructure:"signing_key_id"` // 用于签发新 token 的密钥，为空时使用第一个
+	Keys         []JWTKeyConfig `mapstructure:"keys"`           // 全部可用于校验的密钥，轮换时旧密钥保留到其签发的 token 全部过期；不能为空
+}
+
+// JWTKeyConfig 单个签名密钥，通过 kid 区分
+//   - HS256: 使用 secret
+//   - RS256 / EdDSA: 使用 PEM 格式的私钥文件签名；只用于校验的旧密钥可以只配置公钥文件
+type JWTKeyConfig struct {
+	ID             string `mapstructure:"id"`
+	Algorithm      string `mapstructure:"algorithm"` // HS256 / RS256 / EdDSA
+	Secret         string `mapstructure:"secret"`
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+	PublicKeyFile  string `mapstructure:"public_key_file"`
+}
+
+// RankingConfig 帖子排序公式的参数，修改后启动时会重建对应的排行榜
+type RankingConfig struct {
+	HotDecay       float64 `mapstructure:"hot_decay"`       // hot 排序中净票数相差 10 倍所相当的时间差（秒）
+	BestConfidence float64 `mapstructure:"best_confidence"` // best 排序中 Wilson 置信区间的 z 值
+}
+
+// RateLimitConfig 各路由组的限流规则，key 为路由组名称，未配置的路由组使用代码中的默认规则
+type RateLimitConfig struct {
+	Groups map[string]RateLimitRule `mapstructure:"groups"`
+}
+
+// RateLimitRule 滑动窗口限流规则，Limit 为 0 表示不限流
+type RateLimitRule struct {
+	Limit  int `mapstructure:"limit"`  // 窗口内允许的请求数
+	Window int `mapstructure:"window"` // 窗口长度（秒）
+}
+
+// LoginGuardConfig 登录失败保护，时间单位为秒，未配置的项使用默认值
+type LoginGuardConfig struct {
+	FailureWindow int `mapstructure:"failure_window"`  // 失败次数的统计周期，从最后一次失败开始计算
+	BackoffAfter  int `mapstructure:"backoff_after"`   // 同一用户名连续失败多少次后开始退避
+	BaseBackoff   int `mapstructure:"base_backoff"`    // 第一次退避的时长，之后每多失败一次翻倍
+	MaxBackoff    int `mapstructure:"max_backoff"`     // 退避时长的上限
+	UserLockAfter int `mapstructure:"user_lock_after"` // 同一用户名失败多少次后锁定
+	IPLockAfter   int `mapstructure:"ip_lock_after"`   // 同一 IP 失败多少次后锁定
+	LockDuration  int `mapstructure:"lock_duration"`   // 锁定时长
+}
+
 type LogConfig struct {
 	Level      string `mapstructure:"level"`
 	Filename   string `mapstructure:"filename"`
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/service"
+	"github.com/namelyzz/sayit/utils/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"strconv"
+)
+
+func CreateCommentHandler(c *gin.Context) {
+	postIDStr := c.Param("id")
+	postID, err := strconv.ParseInt(postIDStr, 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	p := new(models.ParamComment)
+	if err = c.ShouldBindJSON(p); err != nil {
+		zap.L().Error("create comment with invalid param", zap.Error(err))
+		handleBindError(c, err)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	comment, err := service.CreateComment(postID, userID, p)
+	if err != nil {
+		if errors.Is(err, api.ErrorPostNotExist) || errors.Is(err, api.ErrorCommentNotExist) {
+			api.ResponseErrorWithMsg(c, api.CodeInvalidParam, err.Error())
+			return
+		}
+		if errors.Is(err, api.ErrorPostLocked) {
+			api.ResponseError(c, api.CodePostLocked)
+			return
+		}
+
+		zap.L().Error("service.CreateComment() failed",
+			zap.Int64("postID", postID),
+			zap.Int64("userID", userID),
+			zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, comment)
+}
+
+func GetCommentListHandler(c *gin.Context) {
+	postIDStr := c.Param("id")
+	postID, err := strconv.ParseInt(postIDStr, 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	p := new(models.ParamCommentList)
+	if err = c.ShouldBindQuery(p); err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+	p.ValidateAndSetDefaults()
+
+	data, err := service.GetCommentTree(postID, p)
+	if err != nil {
+		if errors.Is(err, api.ErrorPostNotExist) {
+			api.ResponseErrorWithMsg(c, api.CodeInvalidParam, err.Error())
+			return
+		}
+		zap.L().Error("service.GetCommentTree() failed",
+			zap.Int64("postID", postID),
+			zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, data)
+}
+
+func GetCommentRepliesHandler(c *gin.Context) {
+	commentIDStr := c.Param("id")
+	commentID, err := strconv.ParseInt(commentIDStr, 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	p := new(models.ParamCommentList)
+	if err = c.ShouldBindQuery(p); err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+	p.ValidateAndSetDefaults()
+
+	data, err := service.GetCommentReplies(commentID, p)
+	if err != nil {
+		if errors.Is(err, api.ErrorPostNotExist) || errors.Is(err, api.ErrorCommentNotExist) {
+			api.ResponseErrorWithMsg(c, api.CodeInvalidParam, err.Error())
+			return
+		}
+		zap.L().Error("service.GetCommentReplies() failed",
+			zap.Int64("commentID", commentID),
+			zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, data)
+}
@@ -2,8 +2,10 @@ package controller
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/namelyzz/sayit/models"
 	"github.com/namelyzz/sayit/service"
 	"github.com/namelyzz/sayit/utils/api"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"strconv"
 )
@@ -34,3 +36,206 @@ func CommunityDetailHandler(c *gin.Context) {
 	}
 	api.ResponseSuccess(c, data)
 }
+
+func CreateCommunityHandler(c *gin.Context) {
+	p := new(models.ParamCreateCommunity)
+	if err := c.ShouldBindJSON(p); err != nil {
+		zap.L().Error("create community with invalid param", zap.Error(err))
+		handleBindError(c, err)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	data, err := service.CreateCommunity(userID, p)
+	if err != nil {
+		if errors.Is(err, api.ErrorCommunityExist) {
+			api.ResponseError(c, api.CodeCommunityExist)
+			return
+		}
+
+		zap.L().Error("service.CreateCommunity() failed", zap.Int64("userID", userID), zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+	api.ResponseSuccess(c, data)
+}
+
+func UpdateCommunityHandler(c *gin.Context) {
+	communityID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	p := new(models.ParamUpdateCommunity)
+	if err = c.ShouldBindJSON(p); err != nil {
+		zap.L().Error("update community with invalid param", zap.Error(err))
+		handleBindError(c, err)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	if err = service.UpdateCommunity(communityID, userID, p); err != nil {
+		handleCommunityError(c, err, "service.UpdateCommunity() failed", communityID, userID)
+		return
+	}
+	api.ResponseSuccess(c, nil)
+}
+
+func AddModeratorHandler(c *gin.Context) {
+	communityID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	p := new(models.ParamAddModerator)
+	if err = c.ShouldBindJSON(p); err != nil {
+		zap.L().Error("add moderator with invalid param", zap.Error(err))
+		handleBindError(c, err)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	if err = service.AddCommunityModerator(communityID, userID, p); err != nil {
+		handleCommunityError(c, err, "service.AddCommunityModerator() failed", communityID, userID)
+		return
+	}
+	api.ResponseSuccess(c, nil)
+}
+
+func PinPostHandler(c *gin.Context) {
+	moderatePost(c, "service.PinPost() failed", func(communityID, postID int64) error {
+		return service.PinPost(communityID, postID, true)
+	})
+}
+
+func UnpinPostHandler(c *gin.Context) {
+	moderatePost(c, "service.PinPost() failed", func(communityID, postID int64) error {
+		return service.PinPost(communityID, postID, false)
+	})
+}
+
+func LockPostHandler(c *gin.Context) {
+	moderatePost(c, "service.LockPost() failed", func(communityID, postID int64) error {
+		return service.LockPost(communityID, postID, true)
+	})
+}
+
+func UnlockPostHandler(c *gin.Context) {
+	moderatePost(c, "service.LockPost() failed", func(communityID, postID int64) error {
+		return service.LockPost(communityID, postID, false)
+	})
+}
+
+func RemovePostHandler(c *gin.Context) {
+	moderatePost(c, "service.RemovePost() failed", func(communityID, postID int64) error {
+		return service.RemovePost(c.Request.Context(), communityID, postID)
+	})
+}
+
+func SubscribeCommunityHandler(c *gin.Context) {
+	communityID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	if err = service.SubscribeCommunity(communityID, userID); err != nil {
+		handleCommunityError(c, err, "service.SubscribeCommunity() failed", communityID, userID)
+		return
+	}
+	api.ResponseSuccess(c, nil)
+}
+
+func UnsubscribeCommunityHandler(c *gin.Context) {
+	communityID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	if err = service.UnsubscribeCommunity(communityID, userID); err != nil {
+		handleCommunityError(c, err, "service.UnsubscribeCommunity() failed", communityID, userID)
+		return
+	}
+	api.ResponseSuccess(c, nil)
+}
+
+// moderatePost 版主操作帖子的公共流程，版主身份已由 CommunityModeratorMiddleware 校验
+func moderatePost(c *gin.Context, msg string, fn func(communityID, postID int64) error) {
+	communityID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+	postID, err := strconv.ParseInt(c.Param("post_id"), 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	if err = fn(communityID, postID); err != nil {
+		if errors.Is(err, api.ErrorPostNotExist) {
+			api.ResponseErrorWithMsg(c, api.CodeInvalidParam, err.Error())
+			return
+		}
+
+		zap.L().Error(msg,
+			zap.Int64("communityID", communityID),
+			zap.Int64("postID", postID),
+			zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+	api.ResponseSuccess(c, nil)
+}
+
+// handleCommunityError 处理创建者管理社区时的错误：社区不存在、非创建者、用户不存在或系统错误
+func handleCommunityError(c *gin.Context, err error, msg string, communityID, userID int64) {
+	if errors.Is(err, api.ErrorInvalidID) {
+		api.ResponseErrorWithMsg(c, api.CodeInvalidParam, err.Error())
+		return
+	}
+	if errors.Is(err, api.ErrorNoPermission) {
+		api.ResponseError(c, api.CodeNoPermission)
+		return
+	}
+	if errors.Is(err, api.ErrorUserNotExist) {
+		api.ResponseError(c, api.CodeUserNotExist)
+		return
+	}
+
+	zap.L().Error(msg,
+		zap.Int64("communityID", communityID),
+		zap.Int64("userID", userID),
+		zap.Error(err))
+	api.ResponseError(c, api.CodeServerBusy)
+}
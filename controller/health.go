@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/service"
+	"github.com/namelyzz/sayit/utils/api"
+	"net/http"
+)
+
+// HealthzHandler 存活检查，进程能处理请求就返回 200，不检查依赖，避免依赖故障时进程被反复重启
+func HealthzHandler(c *gin.Context) {
+	api.ResponseSuccess(c, gin.H{"status": models.HealthStatusUp})
+}
+
+// ReadyzHandler 就绪检查，MySQL 和 Redis 都可用时返回 200，否则返回 503，负载均衡据此摘除实例
+func ReadyzHandler(c *gin.Context) {
+	data := service.CheckReadiness(c.Request.Context())
+	if data.Status != models.HealthStatusUp {
+		api.ResponseErrorWithStatus(c, http.StatusServiceUnavailable, api.CodeServiceNotReady, data)
+		return
+	}
+	api.ResponseSuccess(c, data)
+}
@@ -5,10 +5,14 @@ import (
 	"github.com/namelyzz/sayit/models"
 	"github.com/namelyzz/sayit/service"
 	"github.com/namelyzz/sayit/utils/api"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"strconv"
 )
 
+// NextCursorHeader 帖子列表下一页的游标，没有更多数据时不返回
+const NextCursorHeader = "X-Next-Cursor"
+
 func CreatePostHandler(c *gin.Context) {
 	ctx := c.Request.Context()
 	p := new(models.Post)
@@ -52,6 +56,10 @@ func GetPostDetailHandler(c *gin.Context) {
 
 	data, err := service.GetPostDetailByID(postID)
 	if err != nil {
+		if errors.Is(err, api.ErrorPostNotExist) {
+			api.ResponseErrorWithMsg(c, api.CodeInvalidParam, err.Error())
+			return
+		}
 		zap.L().Error("service.GetPostDetailByID failed", zap.Error(err))
 		api.ResponseError(c, api.CodeServerBusy)
 		return
@@ -81,7 +89,10 @@ func GetPostListHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := service.GetPostList(p)
+	// 未登录时 userID 为 0，不返回当前用户的投票
+	userID, _ := api.GetCurrentUserID(c)
+
+	data, err := service.ListPosts(c.Request.Context(), userID, p)
 	if err != nil {
 		zap.L().Error("get post list failed",
 			zap.Error(err),
@@ -90,5 +101,123 @@ func GetPostListHandler(c *gin.Context) {
 		return
 	}
 
+	// 下一页的游标始终放在响应头中；只有使用游标翻页的请求才返回 {list, next_cursor}，
+	// page/size 翻页的老客户端仍然拿到帖子数组
+	if data.NextCursor != "" {
+		c.Header(NextCursorHeader, data.NextCursor)
+	}
+	if p.Cursor == "" {
+		api.ResponseSuccess(c, data.List)
+		return
+	}
 	api.ResponseSuccess(c, data)
 }
+
+func GetFeedHandler(c *gin.Context) {
+	p := new(models.ParamPostList)
+	if err := c.ShouldBindQuery(p); err != nil {
+		zap.L().Warn("invalid query parameters",
+			zap.Error(err),
+			zap.Any("params", p))
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+	if err := p.ValidateAndSetDefaults(); err != nil {
+		zap.L().Warn("invalid parameters after validation",
+			zap.Error(err),
+			zap.Any("params", p))
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	data, err := service.ListFeed(c.Request.Context(), userID, p)
+	if err != nil {
+		zap.L().Error("service.ListFeed failed", zap.Int64("userID", userID), zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	if data.NextCursor != "" {
+		c.Header(NextCursorHeader, data.NextCursor)
+	}
+	if p.Cursor == "" {
+		api.ResponseSuccess(c, data.List)
+		return
+	}
+	api.ResponseSuccess(c, data)
+}
+
+func UpdatePostHandler(c *gin.Context) {
+	postIDStr := c.Param("id")
+	postID, err := strconv.ParseInt(postIDStr, 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	p := new(models.ParamUpdatePost)
+	if err = c.ShouldBindJSON(p); err != nil {
+		zap.L().Error("update post with invalid param", zap.Error(err))
+		handleBindError(c, err)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	if err = service.UpdatePost(c.Request.Context(), postID, userID, p); err != nil {
+		handlePostOwnerError(c, err, "service.UpdatePost() failed", postID, userID)
+		return
+	}
+
+	api.ResponseSuccess(c, nil)
+}
+
+func DeletePostHandler(c *gin.Context) {
+	postIDStr := c.Param("id")
+	postID, err := strconv.ParseInt(postIDStr, 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	if err = service.DeletePost(c.Request.Context(), postID, userID); err != nil {
+		handlePostOwnerError(c, err, "service.DeletePost() failed", postID, userID)
+		return
+	}
+
+	api.ResponseSuccess(c, nil)
+}
+
+// handlePostOwnerError 处理编辑、删除帖子时的错误：帖子不存在、非作者本人或系统错误
+func handlePostOwnerError(c *gin.Context, err error, msg string, postID, userID int64) {
+	if errors.Is(err, api.ErrorPostNotExist) {
+		api.ResponseErrorWithMsg(c, api.CodeInvalidParam, err.Error())
+		return
+	}
+	if errors.Is(err, api.ErrorNoPermission) {
+		api.ResponseError(c, api.CodeNoPermission)
+		return
+	}
+
+	zap.L().Error(msg,
+		zap.Int64("postID", postID),
+		zap.Int64("userID", userID),
+		zap.Error(err))
+	api.ResponseError(c, api.CodeServerBusy)
+}
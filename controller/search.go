@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/service"
+	"github.com/namelyzz/sayit/utils/api"
+	"go.uber.org/zap"
+)
+
+// SearchHandler 全文搜索帖子，结果按相关度排序
+func SearchHandler(c *gin.Context) {
+	p := new(models.ParamSearch)
+	if err := c.ShouldBindQuery(p); err != nil {
+		handleBindError(c, err)
+		return
+	}
+	if err := p.ValidateAndSetDefaults(); err != nil {
+		api.ResponseErrorWithMsg(c, api.CodeInvalidParam, err.Error())
+		return
+	}
+
+	userID, _ := api.GetCurrentUserID(c)
+
+	data, err := service.SearchPosts(c.Request.Context(), userID, p)
+	if err != nil {
+		zap.L().Error("service.SearchPosts failed", zap.Any("params", p), zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, data)
+}
@@ -8,8 +8,11 @@ import (
 	"github.com/namelyzz/sayit/models"
 	"github.com/namelyzz/sayit/service"
 	"github.com/namelyzz/sayit/utils/api"
+	"github.com/namelyzz/sayit/utils/jwt"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"net/http"
+	"strconv"
 )
 
 func SignupHandler(c *gin.Context) {
@@ -51,9 +54,17 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	user, err := service.Login(p)
+	user, err := service.Login(c.Request.Context(), p, c.ClientIP())
 	if err != nil {
 		zap.L().Error("login failed", zap.String("username", p.Username), zap.Error(err))
+		var blocked *api.LoginBlockedError
+		if errors.As(err, &blocked) {
+			c.Header("Retry-After", strconv.FormatInt(blocked.Seconds(), 10))
+			api.ResponseErrorWithData(c, api.CodeLoginBlocked, blocked.Error(), gin.H{
+				"retry_after": blocked.Seconds(),
+			})
+			return
+		}
 		if errors.Is(err, api.ErrorUserNotExist) {
 			api.ResponseError(c, api.CodeUserNotExist)
 			return
@@ -68,8 +79,199 @@ func LoginHandler(c *gin.Context) {
 	}
 
 	api.ResponseSuccess(c, gin.H{
-		"user_id":   fmt.Sprintf("%d", user.UserID),
-		"user_name": user.Username,
-		"token":     user.Token,
+		"user_id":       fmt.Sprintf("%d", user.UserID),
+		"user_name":     user.Username,
+		"token":         user.Token,
+		"refresh_token": user.RefreshToken,
+	})
+}
+
+func RefreshTokenHandler(c *gin.Context) {
+	p := new(models.ParamRefreshToken)
+	if err := c.ShouldBindJSON(p); err != nil {
+		handleBindError(c, err)
+		return
+	}
+
+	token, refreshToken, err := service.RefreshToken(c.Request.Context(), p.RefreshToken)
+	if err != nil {
+		if errors.Is(err, api.ErrorInvalidToken) {
+			api.ResponseError(c, api.CodeInvalidToken)
+			return
+		}
+
+		zap.L().Error("service.RefreshToken failed", zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
 	})
 }
+
+func LogoutHandler(c *gin.Context) {
+	p := new(models.ParamLogout)
+	// refresh_token 是可选的，允许不带请求体
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(p); err != nil {
+			handleBindError(c, err)
+			return
+		}
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+	jti, expiresAt, err := api.GetCurrentToken(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	if err = service.Logout(c.Request.Context(), userID, jti, expiresAt, p.RefreshToken); err != nil {
+		zap.L().Error("service.Logout failed", zap.Int64("userID", userID), zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, nil)
+}
+
+func LogoutAllHandler(c *gin.Context) {
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	if err = service.LogoutAll(c.Request.Context(), userID); err != nil {
+		zap.L().Error("service.LogoutAll failed", zap.Int64("userID", userID), zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, nil)
+}
+
+// JWKSHandler 公开签名公钥（JWKS 格式），供其他服务自行校验 access token
+func JWKSHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, jwt.JWKS())
+}
+
+func UserProfileHandler(c *gin.Context) {
+	idStr := c.Param("id")
+	userID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	// 未登录时 viewerID 为 0
+	viewerID, _ := api.GetCurrentUserID(c)
+
+	profile, err := service.GetUserProfile(userID, viewerID)
+	if err != nil {
+		if errors.Is(err, api.ErrorUserNotExist) {
+			api.ResponseError(c, api.CodeUserNotExist)
+			return
+		}
+
+		zap.L().Error("service.GetUserProfile failed", zap.Int64("userID", userID), zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, profile)
+}
+
+func UpdateProfileHandler(c *gin.Context) {
+	p := new(models.ParamUpdateProfile)
+	if err := c.ShouldBindJSON(p); err != nil {
+		zap.L().Error("update profile with invalid param", zap.Error(err))
+		handleBindError(c, err)
+		return
+	}
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	profile, err := service.UpdateProfile(userID, p)
+	if err != nil {
+		zap.L().Error("service.UpdateProfile failed", zap.Int64("userID", userID), zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, profile)
+}
+
+func UserPostListHandler(c *gin.Context) {
+	idStr := c.Param("id")
+	authorID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+
+	p := new(models.ParamPostList)
+	if err = c.ShouldBindQuery(p); err != nil {
+		zap.L().Warn("invalid query parameters", zap.Error(err))
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+	if err = p.ValidateAndSetDefaults(); err != nil {
+		api.ResponseErrorWithMsg(c, api.CodeInvalidParam, err.Error())
+		return
+	}
+	// 只能查看用户正常状态的帖子
+	status := int(models.PostStatusNormal)
+	p.Status = &status
+
+	viewerID, _ := api.GetCurrentUserID(c)
+
+	data, err := service.ListUserPosts(c.Request.Context(), viewerID, authorID, p)
+	if err != nil {
+		if errors.Is(err, api.ErrorUserNotExist) {
+			api.ResponseError(c, api.CodeUserNotExist)
+			return
+		}
+
+		zap.L().Error("service.ListUserPosts failed", zap.Int64("authorID", authorID), zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, data)
+}
+
+func MyVoteListHandler(c *gin.Context) {
+	p := new(models.ParamUserVoteList)
+	if err := c.ShouldBindQuery(p); err != nil {
+		zap.L().Warn("invalid query parameters", zap.Error(err))
+		api.ResponseError(c, api.CodeInvalidParam)
+		return
+	}
+	p.ValidateAndSetDefaults()
+
+	userID, err := api.GetCurrentUserID(c)
+	if err != nil {
+		api.ResponseError(c, api.CodeNeedLogin)
+		return
+	}
+
+	data, err := service.ListUserVotes(c.Request.Context(), userID, p)
+	if err != nil {
+		zap.L().Error("service.ListUserVotes failed", zap.Int64("userID", userID), zap.Error(err))
+		api.ResponseError(c, api.CodeServerBusy)
+		return
+	}
+
+	api.ResponseSuccess(c, data)
+}
@@ -0,0 +1,160 @@
+package mysql
+
+import (
+	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/utils/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// CreateComment 写入评论，并在同一个事务中累加帖子的评论数，保证 comment_count 与评论表一致
+func CreateComment(c *models.Comment) (err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("UpdateTime").Create(c).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.Post{}).
+			Where("post_id = ?", c.PostID).
+			UpdateColumn("comment_count", gorm.Expr("comment_count + ?", 1)).Error
+	})
+
+	if err != nil {
+		zap.L().Error("create comment failed",
+			zap.Int64("post_id", c.PostID),
+			zap.Int64("parent_id", c.ParentID),
+			zap.Int64("author_id", c.AuthorID),
+			zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func GetCommentByID(commentID int64) (comment *models.Comment, err error) {
+	comment = new(models.Comment)
+	res := db.Model(&models.Comment{}).
+		Where("comment_id = ?", commentID).
+		First(comment)
+
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, api.ErrorCommentNotExist
+		}
+		return nil, res.Error
+	}
+	return comment, nil
+}
+
+// commentItemQuery 评论查询的公共部分：关联作者名称，只取正常状态的评论
+func commentItemQuery() *gorm.DB {
+	return db.Table("comment c").
+		Select(`c.comment_id, c.post_id, c.parent_id, c.root_id, c.author_id, c.content,
+                c.status, c.create_time, c.update_time, u.username AS author_name`).
+		Joins("LEFT JOIN users u ON c.author_id = u.user_id").
+		Where("c.status = ?", 1)
+}
+
+// GetRootComments 分页获取帖子下的一级评论，按发表时间正序排列
+func GetRootComments(postID int64, page, size int) (items []*models.CommentItem, total int64, err error) {
+	err = db.Model(&models.Comment{}).
+		Where("post_id = ? AND parent_id = 0 AND status = ?", postID, 1).
+		Count(&total).Error
+	if err != nil {
+		zap.L().Error("count root comments failed", zap.Int64("post_id", postID), zap.Error(err))
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	err = commentItemQuery().
+		Where("c.post_id = ? AND c.parent_id = 0", postID).
+		Order("c.create_time ASC, c.comment_id ASC").
+		Offset((page - 1) * size).
+		Limit(size).
+		Scan(&items).Error
+	if err != nil {
+		zap.L().Error("get root comments failed", zap.Int64("post_id", postID), zap.Error(err))
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+/*
+GetRepliesByRootIDs 取出若干一级评论下最早的 limit 条回复，按发表时间正序排列
+
+热门评论下可能有成千上万条回复，不能一次全部取出。按 root_id 分组编号后每组只取前 limit 条，
+剩余的回复通过 GetRepliesByRootID 分页获取。回复一定晚于它回复的评论，所以取出的回复的父评论也都在结果中
+*/
+func GetRepliesByRootIDs(rootIDs []int64, limit int) (items []*models.CommentItem, err error) {
+	if len(rootIDs) == 0 {
+		return nil, nil
+	}
+
+	ranked := db.Table("comment").
+		Select("comment_id, ROW_NUMBER() OVER (PARTITION BY root_id ORDER BY create_time ASC, comment_id ASC) AS rn").
+		Where("root_id IN ? AND status = ?", rootIDs, 1)
+	err = commentItemQuery().
+		Joins("JOIN (?) r ON r.comment_id = c.comment_id", ranked).
+		Where("r.rn <= ?", limit).
+		Order("c.create_time ASC, c.comment_id ASC").
+		Scan(&items).Error
+	if err != nil {
+		zap.L().Error("get replies failed", zap.Int64s("root_ids", rootIDs), zap.Error(err))
+		return nil, err
+	}
+	return items, nil
+}
+
+// CountRepliesByRootIDs 统计若干一级评论下的回复数，没有回复的一级评论不在结果中
+func CountRepliesByRootIDs(rootIDs []int64) (counts map[int64]int64, err error) {
+	counts = make(map[int64]int64, len(rootIDs))
+	if len(rootIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		RootID int64
+		Total  int64
+	}
+	err = db.Model(&models.Comment{}).
+		Select("root_id, COUNT(*) AS total").
+		Where("root_id IN ? AND status = ?", rootIDs, 1).
+		Group("root_id").
+		Scan(&rows).Error
+	if err != nil {
+		zap.L().Error("count replies failed", zap.Int64s("root_ids", rootIDs), zap.Error(err))
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.RootID] = row.Total
+	}
+	return counts, nil
+}
+
+// GetRepliesByRootID 分页获取一条一级评论下的回复，按发表时间正序排列
+func GetRepliesByRootID(rootID int64, page, size int) (items []*models.CommentItem, total int64, err error) {
+	err = db.Model(&models.Comment{}).
+		Where("root_id = ? AND status = ?", rootID, 1).
+		Count(&total).Error
+	if err != nil {
+		zap.L().Error("count replies failed", zap.Int64("root_id", rootID), zap.Error(err))
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	err = commentItemQuery().
+		Where("c.root_id = ?", rootID).
+		Order("c.create_time ASC, c.comment_id ASC").
+		Offset((page - 1) * size).
+		Limit(size).
+		Scan(&items).Error
+	if err != nil {
+		zap.L().Error("get replies failed", zap.Int64("root_id", rootID), zap.Error(err))
+		return nil, 0, err
+	}
+	return items, total, nil
+}
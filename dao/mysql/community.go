@@ -10,7 +10,7 @@ import (
 
 func GetCommunityList() (communities []*models.Community, err error) {
 	res := db.Model(&models.Community{}).
-		Select("community_id", "community_name").
+		Select("community_id", "community_name", "subscriber_count").
 		Find(&communities)
 
 	if res.Error != nil {
@@ -28,7 +28,7 @@ func GetCommunityList() (communities []*models.Community, err error) {
 func GetCommunityDetailByID(id int64) (detail *models.CommunityDetail, err error) {
 	detail = new(models.CommunityDetail)
 	res := db.Model(&models.CommunityDetail{}).
-		Select("community_id", "community_name", "introduction", "create_time").
+		Select("community_id", "community_name", "introduction", "creator_id", "subscriber_count", "create_time").
 		Where("community_id = ?", id).
 		First(detail)
 
@@ -43,3 +43,146 @@ func GetCommunityDetailByID(id int64) (detail *models.CommunityDetail, err error
 
 	return detail, nil
 }
+
+/*
+CreateCommunity 创建社区，并在同一个事务中把创建者设为版主
+
+先查询名称是否已被占用，给出明确的错误；并发创建同名社区时由唯一索引 idx_community_name 兜底
+*/
+func CreateCommunity(c *models.CommunityDetail) (err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.CommunityDetail{}).
+			Where("community_name = ?", c.Name).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return api.ErrorCommunityExist
+		}
+
+		if err := tx.Omit("SubscriberCount").Create(c).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return api.ErrorCommunityExist
+			}
+			return err
+		}
+
+		return tx.Create(&models.CommunityModerator{
+			CommunityID: c.ID,
+			UserID:      c.CreatorID,
+		}).Error
+	})
+
+	if err != nil && !errors.Is(err, api.ErrorCommunityExist) {
+		zap.L().Error("create community failed",
+			zap.String("name", c.Name),
+			zap.Int64("creator_id", c.CreatorID),
+			zap.Error(err))
+	}
+	return err
+}
+
+// UpdateCommunityIntroduction 修改社区简介
+func UpdateCommunityIntroduction(communityID int64, introduction string) (err error) {
+	err = db.Model(&models.CommunityDetail{}).
+		Where("community_id = ?", communityID).
+		Update("introduction", introduction).Error
+	if err != nil {
+		zap.L().Error("update community introduction failed", zap.Int64("community_id", communityID), zap.Error(err))
+	}
+	return err
+}
+
+// AddCommunityModerator 添加版主，用户已经是版主时不做任何修改
+func AddCommunityModerator(communityID, userID int64) (err error) {
+	err = db.Create(&models.CommunityModerator{
+		CommunityID: communityID,
+		UserID:      userID,
+	}).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return nil
+	}
+	if err != nil {
+		zap.L().Error("add community moderator failed",
+			zap.Int64("community_id", communityID),
+			zap.Int64("user_id", userID),
+			zap.Error(err))
+	}
+	return err
+}
+
+// IsCommunityModerator 判断用户是否为社区的版主
+func IsCommunityModerator(communityID, userID int64) (ok bool, err error) {
+	var count int64
+	err = db.Model(&models.CommunityModerator{}).
+		Where("community_id = ? AND user_id = ?", communityID, userID).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// SubscribeCommunity 订阅社区，并在同一个事务中累加订阅人数；已订阅时不做任何修改
+func SubscribeCommunity(communityID, userID int64) (err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&models.CommunitySubscription{
+			CommunityID: communityID,
+			UserID:      userID,
+		}).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&models.CommunityDetail{}).
+			Where("community_id = ?", communityID).
+			UpdateColumn("subscriber_count", gorm.Expr("subscriber_count + ?", 1)).Error
+	})
+
+	if err != nil {
+		zap.L().Error("subscribe community failed",
+			zap.Int64("community_id", communityID),
+			zap.Int64("user_id", userID),
+			zap.Error(err))
+	}
+	return err
+}
+
+// UnsubscribeCommunity 取消订阅社区，只有确实删除了订阅记录时才减少订阅人数
+func UnsubscribeCommunity(communityID, userID int64) (err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("community_id = ? AND user_id = ?", communityID, userID).
+			Delete(&models.CommunitySubscription{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		return tx.Model(&models.CommunityDetail{}).
+			Where("community_id = ? AND subscriber_count > 0", communityID).
+			UpdateColumn("subscriber_count", gorm.Expr("subscriber_count - ?", 1)).Error
+	})
+
+	if err != nil {
+		zap.L().Error("unsubscribe community failed",
+			zap.Int64("community_id", communityID),
+			zap.Int64("user_id", userID),
+			zap.Error(err))
+	}
+	return err
+}
+
+// GetSubscribedCommunityIDs 获取用户订阅的全部社区 id
+func GetSubscribedCommunityIDs(userID int64) (ids []int64, err error) {
+	err = db.Model(&models.CommunitySubscription{}).
+		Where("user_id = ?", userID).
+		Pluck("community_id", &ids).Error
+	if err != nil {
+		zap.L().Error("get subscribed communities failed", zap.Int64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,70 @@
+package mysql
+
+import (
+	"github.com/namelyzz/sayit/utils/metrics"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+const metricsStartKey = "metrics:start_time"
+
+// metricsPlugin GORM 插件，在每类操作的回调前后记录时间，统计 SQL 的耗时
+type metricsPlugin struct{}
+
+func (metricsPlugin) Name() string {
+	return "sayit:metrics"
+}
+
+func (metricsPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("metrics:before_create", beforeMetrics),
+		cb.Create().After("gorm:create").Register("metrics:after_create", afterMetrics("create")),
+		cb.Query().Before("gorm:query").Register("metrics:before_query", beforeMetrics),
+		cb.Query().After("gorm:query").Register("metrics:after_query", afterMetrics("query")),
+		cb.Update().Before("gorm:update").Register("metrics:before_update", beforeMetrics),
+		cb.Update().After("gorm:update").Register("metrics:after_update", afterMetrics("update")),
+		cb.Delete().Before("gorm:delete").Register("metrics:before_delete", beforeMetrics),
+		cb.Delete().After("gorm:delete").Register("metrics:after_delete", afterMetrics("delete")),
+		cb.Row().Before("gorm:row").Register("metrics:before_row", beforeMetrics),
+		cb.Row().After("gorm:row").Register("metrics:after_row", afterMetrics("row")),
+		cb.Raw().Before("gorm:raw").Register("metrics:before_raw", beforeMetrics),
+		cb.Raw().After("gorm:raw").Register("metrics:after_raw", afterMetrics("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func beforeMetrics(db *gorm.DB) {
+	db.InstanceSet(metricsStartKey, time.Now())
+}
+
+func afterMetrics(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.InstanceGet(metricsStartKey)
+		if !ok {
+			return
+		}
+		start, ok := v.(time.Time)
+		if !ok {
+			return
+		}
+
+		// Table("post p") 这类查询的 Statement.Table 为解析出的表名
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		// 查不到记录是正常的业务结果，不算作错误
+		status := metrics.StatusOK
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			status = metrics.StatusError
+		}
+
+		metrics.DBQueryDuration.WithLabelValues(operation, table, status).Observe(time.Since(start).Seconds())
+	}
+}
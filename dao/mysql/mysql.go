@@ -1,8 +1,11 @@
 package mysql
 
 import (
+	"context"
 	"fmt"
 	"github.com/namelyzz/sayit/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -22,6 +25,8 @@ func Init(cfg *config.MySQLConfig) (err error) {
 		Logger: logger.Default.LogMode(logger.Info),
 		// 禁止外键约束（推荐在大部分服务中关闭）
 		DisableForeignKeyConstraintWhenMigrating: true,
+		// 将唯一键冲突等驱动错误转换为 gorm.ErrDuplicatedKey 等通用错误
+		TranslateError: true,
 	}
 
 	// 连接数据库
@@ -42,7 +47,11 @@ func Init(cfg *config.MySQLConfig) (err error) {
 	sqlDB.SetConnMaxLifetime(time.Hour)        // 连接最长存活时间
 	sqlDB.SetConnMaxIdleTime(10 * time.Minute) // 空闲连接最长保持时间
 
-	return nil
+	// SQL 耗时和连接池状态，通过 /metrics 暴露
+	if err = db.Use(metricsPlugin{}); err != nil {
+		return fmt.Errorf("failed to register metrics plugin: %w", err)
+	}
+	return prometheus.Register(collectors.NewDBStatsCollector(sqlDB, cfg.DBName))
 }
 
 // Close 关闭数据库连接
@@ -53,6 +62,15 @@ func Close() {
 	}
 }
 
+// Ping 检查数据库连接是否可用
+func Ping(ctx context.Context) error {
+	sqlDB, err := DB().DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
 // DB 获取全局 GORM 数据库对象
 // 用于在其他包中访问数据库
 func DB() *gorm.DB {
@@ -0,0 +1,149 @@
+package mysql
+
+import (
+	"github.com/namelyzz/sayit/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// maxOutboxErrorLength last_error 列的长度
+const maxOutboxErrorLength = 512
+
+// insertOutboxEvent 在业务事务中写入发件箱事件，event 为 nil 时不写入
+func insertOutboxEvent(tx *gorm.DB, event *models.OutboxEvent) error {
+	if event == nil {
+		return nil
+	}
+	return tx.Omit("Status", "Attempts", "LastError", "ClaimToken", "ClaimExpireTime").Create(event).Error
+}
+
+/*
+ClaimPendingOutboxEvents 按写入顺序认领已到处理时间的事件，认领在 lease 之后过期
+
+SELECT ... FOR UPDATE SKIP LOCKED 跳过其他实例正在认领的行，已被认领且未过期的事件也不会再被认领，
+同一个事件在同一时间只由一个持有者处理。同一个帖子的事件必须按顺序执行，
+例如 post.created 晚于 post.deleted 执行会把已删除的帖子重新加回排行榜，
+所以只选取每个帖子最早的一个待处理事件，之后的事件等它处理完再认领。
+这个条件放在查询中而不是查出之后再过滤，排在前面的事件都在等待重试时，
+LIMIT 取到的仍然是其他帖子可以处理的事件，不会整批落空、卡住整个发件箱。
+子查询不加锁（FOR UPDATE OF o），其他实例正在认领的事件仍然计入，不会因为被跳过而让后面的事件越过它
+*/
+func ClaimPendingOutboxEvents(limit int, token string, lease time.Duration) (events []*models.OutboxEvent, err error) {
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("outbox AS o").
+			Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "o"}, Options: "SKIP LOCKED"}).
+			Where("o.status = ? AND o.next_retry_time <= ?", models.OutboxStatusPending, now).
+			Where("o.claim_expire_time IS NULL OR o.claim_expire_time < ?", now).
+			Where("o.id = (SELECT MIN(h.id) FROM outbox AS h WHERE h.aggregate_id = o.aggregate_id AND h.status = ?)",
+				models.OutboxStatusPending).
+			Order("o.id").
+			Limit(limit).
+			Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		ids := make([]int64, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		return claimOutboxEvents(tx, ids, token, now.Add(lease))
+	})
+	if err != nil {
+		zap.L().Error("claim pending outbox events failed", zap.Error(err))
+		return nil, err
+	}
+	return events, nil
+}
+
+// ClaimOutboxEvent 认领刚写入的事件，供业务事务提交后立即处理；事件已被认领或前面还有同一个帖子的事件时返回 false
+func ClaimOutboxEvent(event *models.OutboxEvent, token string, lease time.Duration) (claimed bool, err error) {
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var locked []*models.OutboxEvent
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND status = ?", event.ID, models.OutboxStatusPending).
+			Where("claim_expire_time IS NULL OR claim_expire_time < ?", now).
+			Find(&locked).Error
+		if err != nil || len(locked) == 0 {
+			return err
+		}
+
+		heads, err := getOutboxAggregateHeads(tx, []int64{event.AggregateID})
+		if err != nil {
+			return err
+		}
+		if heads[event.AggregateID] != event.ID {
+			return nil
+		}
+
+		if err = claimOutboxEvents(tx, []int64{event.ID}, token, now.Add(lease)); err != nil {
+			return err
+		}
+		claimed = true
+		return nil
+	})
+	return claimed, err
+}
+
+// getOutboxAggregateHeads 获取每个业务数据最早的待处理事件 id
+func getOutboxAggregateHeads(tx *gorm.DB, aggregateIDs []int64) (map[int64]int64, error) {
+	var rows []struct {
+		AggregateID int64
+		ID          int64
+	}
+	err := tx.Model(&models.OutboxEvent{}).
+		Select("aggregate_id, MIN(id) AS id").
+		Where("aggregate_id IN ? AND status = ?", aggregateIDs, models.OutboxStatusPending).
+		Group("aggregate_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	heads := make(map[int64]int64, len(rows))
+	for _, row := range rows {
+		heads[row.AggregateID] = row.ID
+	}
+	return heads, nil
+}
+
+func claimOutboxEvents(tx *gorm.DB, ids []int64, token string, expireTime time.Time) error {
+	return tx.Model(&models.OutboxEvent{}).
+		Where("id IN ?", ids).
+		Updates(map[string]interface{}{
+			"claim_token":       token,
+			"claim_expire_time": expireTime,
+		}).Error
+}
+
+// DeleteOutboxEvent 事件处理成功后删除，认领已过期并被其他实例重新认领时不删除
+func DeleteOutboxEvent(id int64, token string) (err error) {
+	return db.Where("id = ? AND claim_token = ?", id, token).Delete(&models.OutboxEvent{}).Error
+}
+
+// MarkOutboxEventFailed 记录一次处理失败并释放认领，giveUp 为 true 时不再重试
+func MarkOutboxEventFailed(id int64, token string, attempts int, nextRetryTime time.Time, lastError string, giveUp bool) (err error) {
+	if len(lastError) > maxOutboxErrorLength {
+		lastError = strings.ToValidUTF8(lastError[:maxOutboxErrorLength], "")
+	}
+
+	values := map[string]interface{}{
+		"attempts":          attempts,
+		"next_retry_time":   nextRetryTime,
+		"last_error":        lastError,
+		"claim_token":       "",
+		"claim_expire_time": nil,
+	}
+	if giveUp {
+		values["status"] = models.OutboxStatusFailed
+	}
+
+	return db.Model(&models.OutboxEvent{}).
+		Where("id = ? AND claim_token = ?", id, token).
+		Updates(values).Error
+}
@@ -2,20 +2,31 @@ package mysql
 
 import (
 	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/utils/api"
+	"github.com/namelyzz/sayit/utils/ranking"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"time"
 )
 
-func CreatePost(p *models.Post) (err error) {
-	res := db.Omit("UpdateTime").Create(p)
-	if res.Error != nil {
+// CreatePost 写入帖子，并在同一个事务中写入发件箱事件，由后台任务把帖子加入 Redis 排行榜
+func CreatePost(p *models.Post, event *models.OutboxEvent) (err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit("UpdateTime", "CommentCount", "UpVotes", "DownVotes", "Pinned", "Locked", "VoteArchived").
+			Create(p).Error
+		if err != nil {
+			return err
+		}
+		return insertOutboxEvent(tx, event)
+	})
+	if err != nil {
 		zap.L().Error("create post failed",
 			zap.String("operation", "create_post"),
 			zap.Int64("author_id", p.AuthorID),
 			zap.Int64("community_id", p.CommunityID),
-			zap.Error(res.Error))
-		return res.Error
+			zap.Error(err))
+		return err
 	}
 	return nil
 }
@@ -23,15 +34,143 @@ func CreatePost(p *models.Post) (err error) {
 func GetPostByID(postID int64) (post *models.Post, err error) {
 	post = new(models.Post)
 	res := db.Model(&models.Post{}).
-		Select("post_id", "title", "content", "author_id", "community_id", "status", "create_time", "update_time").
+		Select("post_id", "title", "content", "author_id", "community_id", "status", "comment_count",
+			"up_votes", "down_votes", "score", "pinned", "locked", "create_time", "update_time").
 		Where("post_id = ?", postID).First(post)
 
 	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, api.ErrorPostNotExist
+		}
 		return nil, res.Error
 	}
 	return post, nil
 }
 
+// UpdatePost 修改帖子标题和内容，update_time 由 autoUpdateTime 自动刷新，同一个事务中写入发件箱事件
+func UpdatePost(postID int64, title, content string, event *models.OutboxEvent) (err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Post{}).
+			Where("post_id = ? AND status = ?", postID, models.PostStatusNormal).
+			Updates(map[string]interface{}{
+				"title":   title,
+				"content": content,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return api.ErrorPostNotExist
+		}
+		return insertOutboxEvent(tx, event)
+	})
+	if err != nil && !errors.Is(err, api.ErrorPostNotExist) {
+		zap.L().Error("update post failed", zap.Int64("post_id", postID), zap.Error(err))
+	}
+	return err
+}
+
+// DeletePost 软删除帖子，只修改 status，保留数据行，同一个事务中写入发件箱事件，由后台任务把帖子移出 Redis
+func DeletePost(postID int64, event *models.OutboxEvent) (err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Post{}).
+			Where("post_id = ? AND status = ?", postID, models.PostStatusNormal).
+			Update("status", models.PostStatusDeleted)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return api.ErrorPostNotExist
+		}
+		return insertOutboxEvent(tx, event)
+	})
+	if err != nil && !errors.Is(err, api.ErrorPostNotExist) {
+		zap.L().Error("delete post failed", zap.Int64("post_id", postID), zap.Error(err))
+	}
+	return err
+}
+
+// SetPostPinned 版主置顶或取消置顶帖子，不刷新 update_time
+func SetPostPinned(postID int64, pinned bool) (err error) {
+	return setPostFlag(postID, "pinned", pinned)
+}
+
+// SetPostLocked 版主锁定或解锁帖子，不刷新 update_time
+func SetPostLocked(postID int64, locked bool) (err error) {
+	return setPostFlag(postID, "locked", locked)
+}
+
+/*
+setPostFlag 修改正常状态的帖子的标记，帖子不存在或已删除时返回 ErrorPostNotExist
+
+值没有变化时 MySQL 返回的 RowsAffected 也是 0，此时再确认一次帖子是否存在，重复置顶、锁定不报错
+*/
+func setPostFlag(postID int64, column string, value bool) (err error) {
+	res := db.Model(&models.Post{}).
+		Where("post_id = ? AND status = ?", postID, models.PostStatusNormal).
+		UpdateColumn(column, value)
+	if res.Error == nil && res.RowsAffected == 0 {
+		var count int64
+		res = db.Model(&models.Post{}).
+			Where("post_id = ? AND status = ?", postID, models.PostStatusNormal).
+			Count(&count)
+		if res.Error == nil && count == 0 {
+			return api.ErrorPostNotExist
+		}
+	}
+	if res.Error != nil {
+		zap.L().Error("set post flag failed",
+			zap.Int64("post_id", postID),
+			zap.String("column", column),
+			zap.Error(res.Error))
+		return res.Error
+	}
+	return nil
+}
+
+/*
+ArchivePostVote 将投票期结束后的最终投票结果写入 MySQL
+
+只更新 vote_archived = 0 的帖子，保证重复执行时不会用空数据覆盖已归档的结果：
+归档任务在写入 MySQL 之后才会删除 Redis 中的投票记录，如果删除前进程崩溃，
+重跑时这里不会再写入（archived 返回 false），调用方只需继续删除 Redis 数据即可。
+score 为 nil 时保留 MySQL 中原有的分数
+*/
+func ArchivePostVote(postID, upVotes, downVotes int64, score *float64) (archived bool, err error) {
+	return updatePostVoteStats(postID, upVotes, downVotes, score, true)
+}
+
+// SyncPostVote 将 Redis 中的实时投票数据同步到 MySQL，已归档的帖子不再更新，score 为 nil 时不更新分数
+func SyncPostVote(postID, upVotes, downVotes int64, score *float64) (err error) {
+	_, err = updatePostVoteStats(postID, upVotes, downVotes, score, false)
+	return err
+}
+
+func updatePostVoteStats(postID, upVotes, downVotes int64, score *float64, archive bool) (updated bool, err error) {
+	values := map[string]interface{}{
+		"up_votes":   upVotes,
+		"down_votes": downVotes,
+	}
+	if score != nil {
+		values["score"] = *score
+	}
+	if archive {
+		values["vote_archived"] = 1
+	}
+
+	res := db.Model(&models.Post{}).
+		Where("post_id = ? AND vote_archived = 0", postID).
+		UpdateColumns(values)
+	if res.Error != nil {
+		zap.L().Error("update post vote stats failed",
+			zap.Int64("post_id", postID),
+			zap.Bool("archive", archive),
+			zap.Error(res.Error))
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
 const (
 	// PostSummaryLength 帖子摘要长度（字符数）
 	PostSummaryLength = 30
@@ -39,21 +178,53 @@ const (
 	PostSummarySuffix = "..."
 )
 
-func GetPostList(p *models.ParamPostList) (posts []*models.PostListItem, err error) {
-	query := db.Model(&models.PostListItem{}).
-		Select(`post_id, title, author_id, community_id, status, 
-                create_time, update_time,
+// postListColumns 帖子列表项对应的列，摘要的长度和后缀由 PostSummaryLength、PostSummarySuffix 填入
+const postListColumns = `p.post_id, p.title, p.author_id, p.community_id, p.status, p.comment_count,
+                p.up_votes AS like_count, p.down_votes AS dislike_count, p.score, p.vote_archived, p.pinned, p.locked,
+                p.create_time, p.update_time, u.username, c.community_name,
                 CASE 
-                    WHEN LENGTH(content) > ? THEN CONCAT(SUBSTRING(content, 1, ?), ?)
-                    ELSE content
-                END as summary`,
-			PostSummaryLength,
-			PostSummaryLength,
-			PostSummarySuffix)
+                    WHEN LENGTH(p.content) > ? THEN CONCAT(SUBSTRING(p.content, 1, ?), ?)
+                    ELSE p.content
+                END as summary`
+
+// postListQuery 帖子列表查询的公共部分：关联作者和社区，截取内容摘要，并带上 MySQL 中持久化的票数
+func postListQuery() *gorm.DB {
+	return postListQueryWith("")
+}
+
+// postListQueryWith 在帖子列表项的基础上额外查询 extra 中的列，args 为 extra 中占位符对应的参数
+func postListQueryWith(extra string, args ...interface{}) *gorm.DB {
+	columns := postListColumns
+	if extra != "" {
+		columns += ", " + extra
+	}
+	return db.Table("post p").
+		Select(columns, append([]interface{}{PostSummaryLength, PostSummaryLength, PostSummarySuffix}, args...)...).
+		Joins("LEFT JOIN users u ON p.author_id = u.user_id").
+		Joins("LEFT JOIN community c ON p.community_id = c.community_id")
+}
+
+/*
+GetPostList 从 MySQL 中按条件查询帖子列表
+
+Redis 排行榜只能处理“按时间/热度 + 社区”的简单查询，带关键字、作者名或者“热度 + 时间范围”的查询都走这里，
+按热度排序依赖 post.score 列，该列由投票同步任务从 Redis 定期写入
+*/
+func GetPostList(p *models.ParamPostList) (posts []*models.PostListItem, err error) {
+	query := postListQuery()
 
 	if p.CommunityID != 0 {
 		query = query.Where("p.community_id = ?", p.CommunityID)
 	}
+	if len(p.CommunityIDs) > 0 {
+		query = query.Where("p.community_id IN ?", p.CommunityIDs)
+	}
+	if len(p.PinnedIDs) > 0 {
+		query = query.Where("p.post_id NOT IN ?", p.PinnedIDs)
+	}
+	if p.AuthorID != 0 {
+		query = query.Where("p.author_id = ?", p.AuthorID)
+	}
 	if p.UserName != "" {
 		query = query.Where("u.username LIKE ?", "%"+p.UserName+"%")
 	}
@@ -68,12 +239,18 @@ func GetPostList(p *models.ParamPostList) (posts []*models.PostListItem, err err
 		query = query.Where("p.create_time <= ?", time.Unix(*p.EndTime, 0))
 	}
 
-	query = query.Where("status = ?", p.Status)
+	query = query.Where("p.status = ?", p.Status)
 
-	if err = applySorting(query, p); err != nil {
-		return nil, err
+	if _, ok := ranking.Get(p.SortBy); ok {
+		query = query.Joins("JOIN (?) recent ON recent.post_id = p.post_id", recentPostIDs(p))
+	}
+
+	if p.After != nil {
+		query = applyCursor(query, p)
 	}
 
+	query = applySorting(query, p)
+
 	if p.Page > 0 && p.Size > 0 {
 		offset := (p.Page - 1) * p.Size
 		query = query.Offset(int(offset)).Limit(int(p.Size))
@@ -90,39 +267,166 @@ func GetPostList(p *models.ParamPostList) (posts []*models.PostListItem, err err
 	return items, nil
 }
 
-func applySorting(query *gorm.DB, p *models.ParamPostList) error {
-	switch p.SortBy {
-	case models.SortFieldCreateTime:
-		query = query.Order("create_time " + string(p.Order))
+// GetPinnedPosts 获取社区中正常状态的置顶帖子，与列表使用相同的排序
+func GetPinnedPosts(p *models.ParamPostList) (posts []*models.PostListItem, err error) {
+	query := postListQuery().
+		Where("p.community_id = ? AND p.pinned = 1 AND p.status = ?", p.CommunityID, models.PostStatusNormal)
+	if err = applySorting(query, p).Scan(&posts).Error; err != nil {
+		zap.L().Error("get pinned posts failed", zap.Int64("community_id", p.CommunityID), zap.Error(err))
+		return nil, err
+	}
+	return posts, nil
+}
+
+// rankCandidateLimit 按排序策略排序时参与排序的帖子数上限
+const rankCandidateLimit = 10000
+
+/*
+recentPostIDs 按排序策略排序时的候选帖子：满足筛选条件的最近发布的 rankCandidateLimit 个帖子
+
+排序策略的分数是现场计算的表达式，没有索引可用，不加限制时每次查询都要对整张表计算后排序。
+只在 Redis 不可用时才会走到这里，先按 create_time 索引取出最近的帖子，只对它们计算分数，
+更早的帖子在降级期间不会出现在这些排序中
+*/
+func recentPostIDs(p *models.ParamPostList) *gorm.DB {
+	query := db.Table("post").Select("post_id").Where("status = ?", p.Status)
+	if p.CommunityID != 0 {
+		query = query.Where("community_id = ?", p.CommunityID)
+	}
+	if len(p.CommunityIDs) > 0 {
+		query = query.Where("community_id IN ?", p.CommunityIDs)
+	}
+	if p.AuthorID != 0 {
+		query = query.Where("author_id = ?", p.AuthorID)
+	}
+	if p.StartTime != nil {
+		query = query.Where("create_time >= ?", time.Unix(*p.StartTime, 0))
+	}
+	if p.EndTime != nil {
+		query = query.Where("create_time <= ?", time.Unix(*p.EndTime, 0))
+	}
+	return query.Order("create_time DESC").Limit(rankCandidateLimit)
+}
+
+// sortColumn 排序字段对应的列，排序策略的分数不在表中保存，使用策略提供的 SQL 表达式现场计算，
+// 候选帖子由 recentPostIDs 限制
+func sortColumn(sortBy models.SortField) string {
+	if s, ok := ranking.Get(sortBy); ok {
+		return s.SQL("p.up_votes", "p.down_votes", "p.create_time")
+	}
+
+	switch sortBy {
 	case models.SortFieldUpdateTime:
-		query = query.Order("update_time " + string(p.Order))
+		return "p.update_time"
 	case models.SortFieldScore:
-		query = query.Order("score " + string(p.Order))
+		return "p.score"
 	default:
-		query = query.Order("create_time " + string(p.Order))
+		return "p.create_time"
 	}
-	return nil
 }
 
+// applySorting 设置排序字段，并以 post_id 作为第二排序字段，保证分数或时间相同时分页结果稳定
+func applySorting(query *gorm.DB, p *models.ParamPostList) *gorm.DB {
+	return query.Order(sortColumn(p.SortBy) + " " + string(p.Order)).
+		Order("p.post_id " + string(p.Order))
+}
+
+/*
+applyCursor 游标分页（keyset pagination）：按 (排序字段, post_id) 定位到上一页最后一条之后
+
+以倒序为例：WHERE col < v OR (col = v AND post_id < id)
+与 OFFSET 不同，新发的帖子不会让后面的页发生偏移，翻到很深的页也能走索引
+*/
+func applyCursor(query *gorm.DB, p *models.ParamPostList) *gorm.DB {
+	column := sortColumn(p.SortBy)
+
+	var value interface{} = p.After.Value
+	if p.SortBy == models.SortFieldCreateTime || p.SortBy == models.SortFieldUpdateTime {
+		value = time.Unix(int64(p.After.Value), 0)
+	}
+
+	op := "<"
+	if p.Order == models.SortDirectionAsc {
+		op = ">"
+	}
+	return query.Where(
+		"("+column+" "+op+" ? OR ("+column+" = ? AND p.post_id "+op+" ?))",
+		value, value, p.After.PostID,
+	)
+}
+
+// GetPostListByIDs 根据帖子 ID 批量获取正常状态的帖子列表，结果按传入 ID 的顺序排列
+// IN 查询不保证返回顺序，而 ID 通常来自 Redis 排行榜，需要保持排行榜中的顺序；
+// 排行榜缓存中可能残留刚被删除的帖子，这里一并过滤掉
 func GetPostListByIDs(postIDs []int64) (posts []*models.PostListItem, err error) {
 	if len(postIDs) == 0 {
 		return nil, nil
 	}
 
 	var items []*models.PostListItem
-	err = db.Model(&models.PostListItem{}).
-		Select(`p.post_id, p.title, p.author_id, p.community_id, p.status, 
-                p.create_time, p.update_time, u.user_name, c.community_name,
-                CASE 
-                    WHEN LENGTH(p.content) > ? THEN CONCAT(SUBSTRING(p.content, 1, ?), ?)
-                    ELSE p.content
-                END as summary`,
-			PostSummaryLength, PostSummaryLength, PostSummarySuffix).
-		Table("post p").
-		Joins("LEFT JOIN users u ON p.author_id = u.user_id").
-		Joins("LEFT JOIN community c ON p.community_id = c.community_id").
-		Where("p.post_id IN ?", postIDs).
-		Find(&items).Error
+	err = postListQuery().
+		Where("p.post_id IN ? AND p.status = ?", postIDs, models.PostStatusNormal).
+		Scan(&items).Error
+	if err != nil {
+		zap.L().Error("get post list by ids failed", zap.Int64s("post_ids", postIDs), zap.Error(err))
+		return nil, err
+	}
+
+	itemMap := make(map[int64]*models.PostListItem, len(items))
+	for _, item := range items {
+		itemMap[item.PostID] = item
+	}
+
+	posts = make([]*models.PostListItem, 0, len(items))
+	for _, id := range postIDs {
+		if item, ok := itemMap[id]; ok {
+			posts = append(posts, item)
+		}
+	}
+	return posts, nil
+}
+
+// ScanNormalPosts 按 post_id 顺序分批获取正常状态的帖子，只包含票数、分数等重建 Redis 索引和排行榜所需的字段
+func ScanNormalPosts(afterID int64, limit int) (posts []*models.Post, err error) {
+	err = db.Model(&models.Post{}).
+		Select("post_id", "community_id", "up_votes", "down_votes", "score", "vote_archived", "create_time").
+		Where("post_id > ? AND status = ?", afterID, models.PostStatusNormal).
+		Order("post_id").
+		Limit(limit).
+		Find(&posts).Error
+	if err != nil {
+		zap.L().Error("scan normal posts failed", zap.Int64("after_id", afterID), zap.Error(err))
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetExistingPostIDs 从给定的帖子 id 中筛选出 MySQL 中存在的帖子，包括已删除的帖子
+func GetExistingPostIDs(postIDs []int64) (ids []int64, err error) {
+	if len(postIDs) == 0 {
+		return nil, nil
+	}
+	err = db.Model(&models.Post{}).
+		Where("post_id IN ?", postIDs).
+		Pluck("post_id", &ids).Error
+	if err != nil {
+		zap.L().Error("get existing post ids failed", zap.Error(err))
+		return nil, err
+	}
+	return ids, nil
+}
 
-	return items, err
+// GetNormalPostIDs 从给定的帖子 id 中筛选出存在且为正常状态的帖子
+func GetNormalPostIDs(postIDs []int64) (ids []int64, err error) {
+	if len(postIDs) == 0 {
+		return nil, nil
+	}
+	err = db.Model(&models.Post{}).
+		Where("post_id IN ? AND status = ?", postIDs, models.PostStatusNormal).
+		Pluck("post_id", &ids).Error
+	if err != nil {
+		zap.L().Error("get normal post ids failed", zap.Error(err))
+		return nil, err
+	}
+	return ids, nil
 }
@@ -0,0 +1,58 @@
+package mysql
+
+import (
+	"github.com/namelyzz/sayit/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"time"
+)
+
+// matchPost 全文索引 idx_ft_title_content 的匹配条件，使用 ngram 分词，中文也能按词命中
+const matchPost = "MATCH(p.title, p.content) AGAINST(? IN NATURAL LANGUAGE MODE)"
+
+/*
+SearchPosts 在帖子标题和内容中全文搜索，按相关度倒序排列
+
+只返回正常状态的帖子；相关度相同时按 post_id 倒序，保证分页结果稳定
+*/
+func SearchPosts(p *models.ParamSearch) (items []*models.SearchPostItem, total int64, err error) {
+	err = applySearchFilters(db.Table("post p"), p).Count(&total).Error
+	if err != nil {
+		zap.L().Error("count search posts failed", zap.Any("params", p), zap.Error(err))
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	err = applySearchFilters(postListQueryWith("p.content, "+matchPost+" AS relevance", p.Q), p).
+		Order("relevance DESC").
+		Order("p.post_id DESC").
+		Offset((p.Page - 1) * p.Size).
+		Limit(p.Size).
+		Scan(&items).Error
+	if err != nil {
+		zap.L().Error("search posts failed", zap.Any("params", p), zap.Error(err))
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+func applySearchFilters(query *gorm.DB, p *models.ParamSearch) *gorm.DB {
+	query = query.Where(matchPost, p.Q).
+		Where("p.status = ?", models.PostStatusNormal)
+
+	if p.CommunityID != 0 {
+		query = query.Where("p.community_id = ?", p.CommunityID)
+	}
+	if p.AuthorID != 0 {
+		query = query.Where("p.author_id = ?", p.AuthorID)
+	}
+	if p.StartTime != nil {
+		query = query.Where("p.create_time >= ?", time.Unix(*p.StartTime, 0))
+	}
+	if p.EndTime != nil {
+		query = query.Where("p.create_time <= ?", time.Unix(*p.EndTime, 0))
+	}
+	return query
+}
@@ -4,6 +4,8 @@ import (
 	"github.com/namelyzz/sayit/models"
 	"github.com/namelyzz/sayit/utils/api"
 	"github.com/namelyzz/sayit/utils/security"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
@@ -20,7 +22,10 @@ func CheckUserExist(username string) (err error) {
 }
 
 func InsertUser(user *models.User) (err error) {
-	user.Password = security.HashPassword(user.Password)
+	user.Password, err = security.HashPassword(user.Password)
+	if err != nil {
+		return err
+	}
 	res := db.Create(user)
 	return res.Error
 }
@@ -44,9 +49,30 @@ func Login(user *models.User) (err error) {
 		return api.ErrorInvalidLogin
 	}
 
+	// 旧版 SHA256 哈希或哈希参数已调整时，借登录成功拿到明文的机会，用当前算法重新哈希
+	// 重新哈希失败不影响本次登录，下次登录时会再次尝试
+	if security.NeedsRehash(user.Password) {
+		rehashPassword(user.UserID, userPwd)
+	}
+
 	return nil
 }
 
+func rehashPassword(userID int64, password string) {
+	hashed, err := security.HashPassword(password)
+	if err != nil {
+		zap.L().Warn("rehash password failed", zap.Int64("user_id", userID), zap.Error(err))
+		return
+	}
+
+	err = db.Model(&models.User{}).
+		Where("user_id = ?", userID).
+		Update("password", hashed).Error
+	if err != nil {
+		zap.L().Warn("update rehashed password failed", zap.Int64("user_id", userID), zap.Error(err))
+	}
+}
+
 func GetUserByID(userID int64) (user *models.User, err error) {
 	user = new(models.User)
 	res := db.Model(&models.User{}).
@@ -55,7 +81,50 @@ func GetUserByID(userID int64) (user *models.User, err error) {
 		First(user)
 
 	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, api.ErrorUserNotExist
+		}
 		return nil, res.Error
 	}
 	return user, nil
 }
+
+// GetUserProfile 获取用户主页信息，发帖数和声望只统计正常状态的帖子
+// 声望使用 post 表中持久化的票数，投票期内的帖子由同步任务定期更新，会有短暂延迟
+func GetUserProfile(userID int64) (profile *models.UserProfile, err error) {
+	profile = new(models.UserProfile)
+	res := db.Table("users u").
+		Select(`u.user_id, u.username, COALESCE(u.email, '') AS email, u.gender, u.bio, u.avatar,
+                u.create_time AS join_time,
+                (SELECT COUNT(*) FROM post p WHERE p.author_id = u.user_id AND p.status = ?) AS post_count,
+                (SELECT COALESCE(SUM(p.up_votes - p.down_votes), 0) FROM post p
+                    WHERE p.author_id = u.user_id AND p.status = ?) AS karma`,
+			models.PostStatusNormal, models.PostStatusNormal).
+		Where("u.user_id = ?", userID).
+		Take(profile)
+
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, api.ErrorUserNotExist
+		}
+		zap.L().Error("get user profile failed", zap.Int64("user_id", userID), zap.Error(res.Error))
+		return nil, res.Error
+	}
+	return profile, nil
+}
+
+// UpdateUserProfile 修改用户资料，values 的 key 为列名
+func UpdateUserProfile(userID int64, values map[string]interface{}) (err error) {
+	if len(values) == 0 {
+		return nil
+	}
+
+	res := db.Model(&models.User{}).
+		Where("user_id = ?", userID).
+		Updates(values)
+	if res.Error != nil {
+		zap.L().Error("update user profile failed", zap.Int64("user_id", userID), zap.Error(res.Error))
+		return res.Error
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// IdempotencyRecord 幂等键对应的记录，Done 为 false 表示首次请求仍在处理中
+type IdempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"` // 请求方法、路径和请求体的哈希
+	Done        bool   `json:"done"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+func getIdempotencyKey(userID int64, key string) string {
+	return getRedisKey(KeyIdempotencyPF + strconv.FormatInt(userID, 10) + ":" + key)
+}
+
+/*
+ReserveIdempotencyKey 占用幂等键
+
+幂等键不存在时写入一条处理中的记录并返回 nil，调用方继续处理请求；
+已存在时返回已有的记录，由调用方决定回放响应还是拒绝请求
+*/
+func ReserveIdempotencyKey(ctx context.Context, userID int64, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	b, err := json.Marshal(&IdempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+
+	redisKey := getIdempotencyKey(userID, key)
+	// SET NX 失败后记录可能恰好过期，此时再尝试占用一次
+	for i := 0; i < 2; i++ {
+		ok, err := client.SetNX(ctx, redisKey, b, ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nil, nil
+		}
+
+		val, err := client.Get(ctx, redisKey).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record := new(IdempotencyRecord)
+		if err = json.Unmarshal(val, record); err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+	return &IdempotencyRecord{Fingerprint: fingerprint}, nil
+}
+
+// RefreshIdempotencyReservation 首次请求仍在处理时延长占用时长，避免处理较慢的请求在完成前被重复处理
+func RefreshIdempotencyReservation(ctx context.Context, userID int64, key string, ttl time.Duration) error {
+	return client.Expire(ctx, getIdempotencyKey(userID, key), ttl).Err()
+}
+
+// SaveIdempotencyResponse 保存首次请求的响应，有效期从保存时重新计算
+func SaveIdempotencyResponse(ctx context.Context, userID int64, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	record.Done = true
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, getIdempotencyKey(userID, key), b, ttl).Err()
+}
+
+// ReleaseIdempotencyKey 首次请求处理失败时释放幂等键，允许客户端用同一个键重试
+func ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	return client.Del(ctx, getIdempotencyKey(userID, key)).Err()
+}
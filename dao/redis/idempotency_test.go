@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	mr := setupMiniRedis(t)
+	ctx := context.Background()
+
+	// 首次占用成功
+	record, err := ReserveIdempotencyKey(ctx, 1, "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, record)
+
+	// 处理完成前重试，拿到处理中的记录
+	record, err = ReserveIdempotencyKey(ctx, 1, "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.False(t, record.Done)
+
+	// 处理期间续期，超过最初的占用时长后仍然是处理中
+	mr.FastForward(40 * time.Second)
+	require.NoError(t, RefreshIdempotencyReservation(ctx, 1, "k1", time.Minute))
+	mr.FastForward(40 * time.Second)
+	record, err = ReserveIdempotencyKey(ctx, 1, "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.False(t, record.Done)
+
+	// 同一个键属于不同用户，互不影响
+	record, err = ReserveIdempotencyKey(ctx, 2, "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, record)
+
+	resp := &IdempotencyRecord{Fingerprint: "fp", Status: 200, ContentType: "application/json", Body: []byte(`{"code":1000}`)}
+	require.NoError(t, SaveIdempotencyResponse(ctx, 1, "k1", resp, time.Hour))
+
+	record, err = ReserveIdempotencyKey(ctx, 1, "k1", "other", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.True(t, record.Done)
+	assert.Equal(t, "fp", record.Fingerprint)
+	assert.Equal(t, resp.Body, record.Body)
+
+	// 释放后可以重新占用
+	require.NoError(t, ReleaseIdempotencyKey(ctx, 1, "k1"))
+	record, err = ReserveIdempotencyKey(ctx, 1, "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, record)
+}
@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// IncrLoginFailure 累加 subject 的登录失败次数并返回累加后的次数，计数在最后一次失败 window 之后过期
+func IncrLoginFailure(ctx context.Context, subject string, window time.Duration) (int64, error) {
+	key := getRedisKey(KeyLoginFailPF + subject)
+
+	pipe := client.TxPipeline()
+	incrCmd := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incrCmd.Val(), nil
+}
+
+// BlockLogin 在 d 时间内暂停 subject 登录
+func BlockLogin(ctx context.Context, subject string, d time.Duration) error {
+	return client.Set(ctx, getRedisKey(KeyLoginBlockPF+subject), 1, d).Err()
+}
+
+// GetLoginBlock 返回 subjects 中剩余暂停时间最长的一个，都没有被暂停时返回 0
+func GetLoginBlock(ctx context.Context, subjects ...string) (time.Duration, error) {
+	pipe := client.Pipeline()
+	cmds := make([]*redis.DurationCmd, 0, len(subjects))
+	for _, subject := range subjects {
+		cmds = append(cmds, pipe.PTTL(ctx, getRedisKey(KeyLoginBlockPF+subject)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	// key 不存在时 PTTL 返回负数
+	var longest time.Duration
+	for _, cmd := range cmds {
+		longest = max(longest, cmd.Val())
+	}
+	return longest, nil
+}
+
+// ResetLoginFailure 登录成功后清除 subjects 的失败次数和暂停标记
+func ResetLoginFailure(ctx context.Context, subjects ...string) error {
+	keys := make([]string, 0, 2*len(subjects))
+	for _, subject := range subjects {
+		keys = append(keys, getRedisKey(KeyLoginFailPF+subject), getRedisKey(KeyLoginBlockPF+subject))
+	}
+	return client.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,46 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginFailure(t *testing.T) {
+	mr := setupMiniRedis(t)
+	ctx := context.Background()
+
+	for i := int64(1); i <= 3; i++ {
+		n, err := IncrLoginFailure(ctx, "user:alice", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, i, n)
+	}
+
+	d, err := GetLoginBlock(ctx, "user:alice", "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+
+	require.NoError(t, BlockLogin(ctx, "user:alice", 10*time.Second))
+	require.NoError(t, BlockLogin(ctx, "ip:127.0.0.1", time.Minute))
+	d, err = GetLoginBlock(ctx, "user:alice", "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, d)
+
+	// 暂停到期后可以再次登录
+	mr.FastForward(time.Minute)
+	d, err = GetLoginBlock(ctx, "user:alice", "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+
+	require.NoError(t, BlockLogin(ctx, "user:alice", time.Minute))
+	require.NoError(t, ResetLoginFailure(ctx, "user:alice"))
+	d, err = GetLoginBlock(ctx, "user:alice")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+	n, err := IncrLoginFailure(ctx, "user:alice", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"context"
+	"github.com/namelyzz/sayit/utils/metrics"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// metricsHook 记录每条 Redis 命令的耗时，按命令名统计
+type metricsHook struct{}
+
+func (metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		observeCommand(cmd.Name(), start, err)
+		return err
+	}
+}
+
+func (metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		observeCommand("pipeline", start, err)
+		return err
+	}
+}
+
+func observeCommand(name string, start time.Time, err error) {
+	// key 不存在是正常的查询结果，不算作错误
+	status := metrics.StatusOK
+	if err != nil && !errors.Is(err, redis.Nil) {
+		status = metrics.StatusError
+	}
+	metrics.RedisCommandDuration.WithLabelValues(name, status).Observe(time.Since(start).Seconds())
+}
+
+// poolStatsCollector 在每次采集时读取连接池的状态
+type poolStatsCollector struct {
+	client *redis.Client
+
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	timeouts   *prometheus.Desc
+	totalConns *prometheus.Desc
+	idleConns  *prometheus.Desc
+	staleConns *prometheus.Desc
+}
+
+func newPoolStatsCollector(c *redis.Client) *poolStatsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("sayit_redis_pool_"+name, help, nil, nil)
+	}
+	return &poolStatsCollector{
+		client:     c,
+		hits:       desc("hits_total", "从连接池中取到空闲连接的次数"),
+		misses:     desc("misses_total", "连接池中没有空闲连接、需要新建连接的次数"),
+		timeouts:   desc("timeouts_total", "等待连接池中的连接超时的次数"),
+		totalConns: desc("total_conns", "连接池中的连接数"),
+		idleConns:  desc("idle_conns", "连接池中的空闲连接数"),
+		staleConns: desc("stale_conns_total", "因过期被移出连接池的连接数"),
+	}
+}
+
+func (c *poolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.timeouts
+	ch <- c.totalConns
+	ch <- c.idleConns
+	ch <- c.staleConns
+}
+
+func (c *poolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.client.PoolStats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(s.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(s.TotalConns))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(s.IdleConns))
+	ch <- prometheus.MustNewConstMetric(c.staleConns, prometheus.CounterValue, float64(s.StaleConns))
+}
@@ -0,0 +1,33 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/namelyzz/sayit/utils/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsHook(t *testing.T) {
+	setupMiniRedis(t)
+	client.AddHook(metricsHook{})
+	ctx := context.Background()
+	metrics.RedisCommandDuration.Reset()
+
+	require.NoError(t, client.Set(ctx, "k", "v", 0).Err())
+	// key 不存在不算作错误
+	require.Error(t, client.Get(ctx, "missing").Err())
+	pipe := client.Pipeline()
+	pipe.Get(ctx, "k")
+	_, err := pipe.Exec(ctx)
+	require.NoError(t, err)
+
+	// 建立连接时的握手命令也会被记录，这里只检查 set、get、pipeline 各记录一次，且都记为 ok
+	for _, cmd := range []string{"set", "get", "pipeline"} {
+		h := metrics.RedisCommandDuration.WithLabelValues(cmd, metrics.StatusOK).(prometheus.Histogram)
+		assert.Equal(t, 1, testutil.CollectAndCount(h), cmd)
+	}
+}
@@ -2,20 +2,25 @@ package redis
 
 import (
 	"context"
-	"fmt"
 	"github.com/namelyzz/sayit/models"
 	"github.com/redis/go-redis/v9"
+	"hash/fnv"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
-func CreatePost(ctx context.Context, postID, communityID int64, score float64) error {
+// CreatePost 将新帖子加入时间榜、热度榜、各排序策略的排行榜以及所属社区，ranks 为帖子在各排序策略下的初始分数
+// 使用 ZADD NX，重复执行不会覆盖投票后的分数
+func CreatePost(ctx context.Context, postID, communityID int64, score float64, ranks map[models.SortField]float64) error {
+	member := postMember(strconv.FormatInt(postID, 10))
 	pipe := client.TxPipeline()
 
 	// 按时间入榜：将帖子加入“最新发布”排行榜
-	pipe.ZAdd(ctx, getRedisKey(KeyPostTimeZset), redis.Z{
+	pipe.ZAddNX(ctx, getRedisKey(KeyPostTimeZset), redis.Z{
 		Score:  score,
-		Member: postID,
+		Member: member,
 	})
 
 	// 按分数入榜：将帖子加入“综合热度”排行榜（初始分数设为当前时间戳）。
@@ -23,91 +28,470 @@ func CreatePost(ctx context.Context, postID, communityID int64, score float64) e
 	// 1.为了保证新帖子有曝光机会：
 	// 刚发布的帖子没有投票，如果分数为 0，它会沉底。将分数初始化为当前时间戳，能保证新发布的帖子暂时排在前面（比旧帖子分数高）。
 	// 2.随着时间推移，旧帖子的“时间分”虽然小，但如果它的“投票分”很高，总分就会超过这个新帖子。
-	pipe.ZAdd(ctx, getRedisKey(KeyPostScoreZset), redis.Z{
+	pipe.ZAddNX(ctx, getRedisKey(KeyPostScoreZset), redis.Z{
 		Score:  score,
-		Member: postID,
+		Member: member,
 	})
 
+	for sortBy, rank := range ranks {
+		pipe.ZAddNX(ctx, getRankKey(sortBy), redis.Z{
+			Score:  rank,
+			Member: member,
+		})
+	}
+
 	// 社区关联：将帖子 ID 记录到对应社区的集合中。
 	// 用于快速查找某个社区内的帖子列表
 	cKey := getRedisKey(KeyCommunitySetPF + strconv.Itoa(int(communityID)))
-	pipe.SAdd(ctx, cKey, postID)
+	pipe.SAdd(ctx, cKey, member)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+/*
+DeletePost 将帖子从时间榜、热度榜和所属社区中移除
+
+投票脚本会检查帖子是否在时间榜中，移除之后不再接受新的投票。
+投票记录 post:voted:<id> 保留下来，由调用方把最终票数归档到 MySQL 之后再用 DeletePostVotes 清理
+*/
+func DeletePost(ctx context.Context, postID, communityID int64) error {
+	member := postMember(strconv.FormatInt(postID, 10))
+	pipe := client.TxPipeline()
+
+	pipe.ZRem(ctx, getRedisKey(KeyPostTimeZset), member)
+	pipe.ZRem(ctx, getRedisKey(KeyPostScoreZset), member)
+	for _, sortBy := range models.RankSortFields {
+		pipe.ZRem(ctx, getRankKey(sortBy), member)
+	}
+
+	cKey := getRedisKey(KeyCommunitySetPF + strconv.Itoa(int(communityID)))
+	pipe.SRem(ctx, cKey, member)
+
+	// 同时从社区排行缓存中移除，避免缓存过期前仍能查到已删除的帖子
+	pipe.ZRem(ctx, getCommunityPostCacheKey(communityID, getRedisKey(KeyPostTimeZset)), member)
+	pipe.ZRem(ctx, getCommunityPostCacheKey(communityID, getRedisKey(KeyPostScoreZset)), member)
+	for _, sortBy := range models.RankSortFields {
+		pipe.ZRem(ctx, getCommunityPostCacheKey(communityID, getRankKey(sortBy)), member)
+	}
 	_, err := pipe.Exec(ctx)
 	return err
 }
 
-// GetPostIDsInOrder 从Redis中获取排序后的帖子ID列表
-func GetPostIDsInOrder(ctx context.Context, p *models.ParamPostList) (res []string, err error) {
-	targetKey, err := genPostKey(ctx, p.CommunityID, p.SortBy)
+/*
+GetPostIDsInOrder 从Redis中获取排序后的帖子ID列表，Member 为帖子 ID，Score 为排行榜中的分数
+
+排行榜中的 member 是补零的帖子 ID，分数相同的帖子按帖子 ID 的数值排序（倒序时 ID 大的在前），
+与 MySQL 中以 post_id 作为第二排序字段一致，两条路径发出的游标可以互相使用
+*/
+func GetPostIDsInOrder(ctx context.Context, p *models.ParamPostList) (res []redis.Z, err error) {
+	targetKey, err := genPostKey(ctx, p)
 	if err != nil {
 		return nil, err
 	}
 
+	if p.After != nil {
+		// 场景 3: 游标分页
+		res, err = getPostIDsAfterCursor(ctx, targetKey, p)
+		return decodePostMembers(res), err
+	}
+
+	desc := p.Order == models.SortDirectionDesc
+	start := int64((p.Page - 1) * p.Size)
 	if p.SortBy == models.SortFieldCreateTime && (p.StartTime != nil || p.EndTime != nil) {
 		// 场景1：按时间排序 且 有时间范围限制 -> 使用 ZRangeByScore
-		minTime, maxTime := "-inf", "+inf"
-		if p.StartTime != nil {
-			minTime = strconv.FormatInt(*p.StartTime, 10)
-		}
-		if p.EndTime != nil {
-			maxTime = strconv.FormatInt(*p.EndTime, 10)
-		}
-
+		minScore, maxScore := timeRange(p)
 		opt := &redis.ZRangeBy{
-			Min:    minTime,
-			Max:    maxTime,
-			Offset: int64((p.Page - 1) * p.Size),
+			Min:    minScore,
+			Max:    maxScore,
+			Offset: start,
 			Count:  int64(p.Size),
 		}
 
-		if p.Order == models.SortDirectionDesc {
-			res, err = client.ZRevRangeByScore(ctx, targetKey, opt).Result()
+		if desc {
+			res, err = client.ZRevRangeByScoreWithScores(ctx, targetKey, opt).Result()
 		} else {
-			res, err = client.ZRangeByScore(ctx, targetKey, opt).Result()
+			res, err = client.ZRangeByScoreWithScores(ctx, targetKey, opt).Result()
 		}
 	} else {
 		// 场景 2: 普通翻页 (无时间范围，纯按排名) -> 使用 ZRange
-		start := int64((p.Page - 1) * p.Size)
 		stop := int64(p.Page*p.Size - 1)
 
-		if p.Order == models.SortDirectionDesc {
-			res, err = client.ZRevRange(ctx, targetKey, start, stop).Result()
+		if desc {
+			res, err = client.ZRevRangeWithScores(ctx, targetKey, start, stop).Result()
 		} else {
-			res, err = client.ZRange(ctx, targetKey, start, stop).Result()
+			res, err = client.ZRangeWithScores(ctx, targetKey, start, stop).Result()
 		}
 	}
+	return decodePostMembers(res), err
+}
+
+// timeRange 将请求中的时间范围转换为 ZRangeByScore 的上下界
+func timeRange(p *models.ParamPostList) (minTime, maxTime string) {
+	minTime, maxTime = "-inf", "+inf"
+	if p.SortBy != models.SortFieldCreateTime {
+		return
+	}
+	if p.StartTime != nil {
+		minTime = strconv.FormatInt(*p.StartTime, 10)
+	}
+	if p.EndTime != nil {
+		maxTime = strconv.FormatInt(*p.EndTime, 10)
+	}
+	return
+}
+
+/*
+postsAfterCursorScript 从游标位置开始按排名取下一页
+
+zset 按 (score, member) 排列，member 是补零的帖子 ID，与游标 (score, 帖子 ID) 的顺序一致。
+先用 ZCOUNT 找到与游标同分的区间，再在区间内二分查找游标的位置（游标对应的帖子可能已被删除），
+之后按排名取 count 个，只访问 O(log n) 个元素，不会取出整组同分的帖子
+
+KEYS[1]: 排行榜
+ARGV[1]: 游标的 score   ARGV[2]: 游标的 member   ARGV[3]: 1 倒序，0 正序   ARGV[4]: 取的个数
+*/
+var postsAfterCursorScript = redis.NewScript(`
+local member = ARGV[2]
+local desc = ARGV[3] == '1'
+local lo = redis.call('ZCOUNT', KEYS[1], '-inf', '(' .. ARGV[1])
+local hi = lo + redis.call('ZCOUNT', KEYS[1], ARGV[1], ARGV[1])
+
+-- 正序时找第一个排在游标之后的位置，倒序时找第一个不排在游标之前的位置
+while lo < hi do
+	local mid = math.floor((lo + hi) / 2)
+	local m = redis.call('ZRANGE', KEYS[1], mid, mid)[1]
+	if m < member or (not desc and m == member) then
+		lo = mid + 1
+	else
+		hi = mid
+	end
+end
+
+local count = tonumber(ARGV[4])
+if not desc then
+	return redis.call('ZRANGE', KEYS[1], lo, lo + count - 1, 'WITHSCORES')
+end
+if lo == 0 then
+	return {}
+end
+
+local res = redis.call('ZRANGE', KEYS[1], math.max(lo - count, 0), lo - 1, 'WITHSCORES')
+local out = {}
+for i = #res - 1, 1, -2 do
+	out[#out + 1] = res[i]
+	out[#out + 1] = res[i + 1]
+end
+return out
+`)
+
+// getPostIDsAfterCursor 从游标位置开始取下一页，有时间范围时去掉超出范围的帖子
+func getPostIDsAfterCursor(ctx context.Context, key string, p *models.ParamPostList) (res []redis.Z, err error) {
+	desc := p.Order == models.SortDirectionDesc
+	order := "0"
+	if desc {
+		order = "1"
+	}
+
+	vals, err := postsAfterCursorScript.Run(ctx, client, []string{key},
+		strconv.FormatFloat(p.After.Value, 'f', -1, 64),
+		postMember(strconv.FormatInt(p.After.PostID, 10)),
+		order, p.Size).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	res = make([]redis.Z, 0, len(vals)/2)
+	for i := 0; i+1 < len(vals); i += 2 {
+		score, err := strconv.ParseFloat(vals[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		if p.SortBy == models.SortFieldCreateTime {
+			beforeStart := p.StartTime != nil && score < float64(*p.StartTime)
+			afterEnd := p.EndTime != nil && score > float64(*p.EndTime)
+			// 结果是有序的，越过翻页方向上的边界之后，剩下的帖子也都在范围之外
+			if (desc && beforeStart) || (!desc && afterEnd) {
+				break
+			}
+			if beforeStart || afterEnd {
+				continue
+			}
+		}
+		res = append(res, redis.Z{Score: score, Member: vals[i]})
+	}
+	return res, nil
+}
+
+// postMemberWidth int64 最大值的十进制位数
+const postMemberWidth = 19
+
+/*
+postMember 帖子在时间榜、热度榜、各排序策略的排行榜和社区集合中的 member：左侧补零到固定位数的帖子 ID
+
+zset 中同分的 member 按字典序排列，补零后字典序与帖子 ID 的数值顺序一致，分页时按排名直接取即可。
+投票记录、用户的投票历史等其他 key 中仍然使用不补零的帖子 ID
+*/
+func postMember(postID string) string {
+	if len(postID) >= postMemberWidth {
+		return postID
+	}
+	return strings.Repeat("0", postMemberWidth-len(postID)) + postID
+}
+
+// postIDOfMember 排行榜 member 对应的帖子 ID，兼容没有补零的旧数据
+func postIDOfMember(member string) string {
+	id := strings.TrimLeft(member, "0")
+	if id == "" {
+		return "0"
+	}
+	return id
+}
+
+// isLegacyPostMember 判断 member 是否为没有补零的旧格式
+func isLegacyPostMember(member string) bool {
+	return len(member) < postMemberWidth
+}
+
+// decodePostMembers 将排行榜的 member 转换为帖子 ID
+func decodePostMembers(zs []redis.Z) []redis.Z {
+	for i := range zs {
+		if member, ok := zs[i].Member.(string); ok {
+			zs[i].Member = postIDOfMember(member)
+		}
+	}
+	return zs
+}
+
+// memberID 排行榜 member 对应的帖子 ID
+func memberID(z redis.Z) int64 {
+	member, _ := z.Member.(string)
+	id, _ := strconv.ParseInt(member, 10, 64)
+	return id
+}
+
+// communityPostCacheTTL 社区帖子排行缓存的有效期
+// 缓存期间新发的帖子不会出现在社区列表中，投票带来的分数变化也不会体现，所以有效期不宜过长
+const communityPostCacheTTL = 60 * time.Second
+
+// postCacheReadGrace 命中排行缓存时保证它至少还能存在的时间，避免调用方读取之前缓存恰好过期，读到空列表
+const postCacheReadGrace = 5 * time.Second
+
+// emptyPostCacheSuffix 排行缓存计算结果为空时写入的标记 key 的后缀
+const emptyPostCacheSuffix = ":empty"
+
+/*
+hitPostCacheScript 检查排行缓存或空结果标记是否存在，存在时把剩余有效期延长到至少 ARGV[1] 毫秒
+
+检查和延长在同一个脚本中执行，之后的读取一定能读到这份缓存
+
+KEYS[1]: 排行缓存
+KEYS[2]: 空结果标记
+*/
+var hitPostCacheScript = redis.NewScript(`
+for i, key in ipairs(KEYS) do
+	local ttl = redis.call('PTTL', key)
+	if ttl ~= -2 then
+		if ttl >= 0 and ttl < tonumber(ARGV[1]) then
+			redis.call('PEXPIRE', key, ARGV[1])
+		end
+		return i
+	end
+end
+return 0
+`)
+
+// hitPostCache 排行缓存存在或已缓存为空结果时返回 true
+func hitPostCache(ctx context.Context, cacheKey string) (bool, error) {
+	hit, err := hitPostCacheScript.Run(ctx, client, []string{cacheKey, cacheKey + emptyPostCacheSuffix},
+		postCacheReadGrace.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return hit > 0, nil
+}
+
+// markEmptyPostCache 结果为空时 ZINTERSTORE 等命令不会创建 key，写入一个空结果标记，有效期内不再重复计算
+func markEmptyPostCache(ctx context.Context, cacheKey string) error {
+	return client.Set(ctx, cacheKey+emptyPostCacheSuffix, 1, communityPostCacheTTL).Err()
+}
+
+// getCommunityPostCacheKey 社区帖子排行缓存的 key，baseKey 为时间榜或热度榜
+func getCommunityPostCacheKey(commID int64, baseKey string) string {
+	return getRedisKey(KeyCommunityPostCachePF + strconv.FormatInt(commID, 10) + ":" + strings.TrimPrefix(baseKey, Prefix))
+}
 
-	return res, err
+// getRankKey 排序策略对应的排行榜
+func getRankKey(sortBy models.SortField) string {
+	return getRedisKey(KeyPostRankZsetPF + string(sortBy))
 }
 
 // genPostKey 确定基础 key 以及是否需要聚合计算
-func genPostKey(ctx context.Context, commID int64, sortBy models.SortField) (targetKey string, err error) {
-	baseKey := getRedisKey(KeyPostScoreZset)
-	if sortBy == models.SortFieldCreateTime {
+func genPostKey(ctx context.Context, p *models.ParamPostList) (targetKey string, err error) {
+	var baseKey string
+	switch {
+	case p.SortBy == models.SortFieldCreateTime:
 		baseKey = getRedisKey(KeyPostTimeZset)
+	case p.SortBy.IsRank():
+		baseKey = getRankKey(p.SortBy)
+	default:
+		baseKey = getRedisKey(KeyPostScoreZset)
+	}
+
+	if len(p.CommunityIDs) > 0 {
+		return genFeedKey(ctx, p.CommunityIDs, baseKey)
+	}
+	if p.CommunityID > 0 && len(p.PinnedIDs) > 0 {
+		return genUnpinnedPostKey(ctx, p.CommunityID, p.PinnedIDs, baseKey)
 	}
+	return genCommunityPostKey(ctx, p.CommunityID, baseKey)
+}
 
+// genCommunityPostKey commID 为 0 时直接使用全站排行榜，否则使用社区排行缓存，缓存不存在时重新计算
+func genCommunityPostKey(ctx context.Context, commID int64, baseKey string) (targetKey string, err error) {
 	targetKey = baseKey
 	if commID > 0 {
 		communityKey := getRedisKey(KeyCommunitySetPF + strconv.Itoa(int(commID)))
 
-		tempKey := fmt.Sprintf("temp:post:%d:%d", commID, time.Now().UnixNano())
-		defer client.Del(ctx, tempKey)
+		// 社区排行的交集结果缓存一小段时间，避免每个请求都重新做一次 ZInterStore
+		cacheKey := getCommunityPostCacheKey(commID, baseKey)
+		hit, err := hitPostCache(ctx, cacheKey)
+		if err != nil {
+			return "", err
+		}
+		if hit {
+			return cacheKey, nil
+		}
 
 		// AGGREGATE MAX:
 		// 社区 Set 里的分数通常是 0 或无关紧要。
 		// ZSet 里的分数是时间戳或热度。
 		// 取 MAX 或 SUM 都能保留原 ZSet 的分数特性（前提是 Set 里分数不干扰）。
-		err = client.ZInterStore(ctx, tempKey, &redis.ZStore{
+		pipe := client.TxPipeline()
+		countCmd := pipe.ZInterStore(ctx, cacheKey, &redis.ZStore{
 			Keys:      []string{baseKey, communityKey},
 			Weights:   []float64{1, 0}, // 权重: ZSet=1, CommunitySet=0 (忽略社区Set原本的分数)
 			Aggregate: "MAX",
-		}).Err()
-		if err != nil {
+		})
+		pipe.Expire(ctx, cacheKey, communityPostCacheTTL)
+		if _, err = pipe.Exec(ctx); err != nil {
 			return "", err
 		}
-		targetKey = tempKey
+		if countCmd.Val() == 0 {
+			if err = markEmptyPostCache(ctx, cacheKey); err != nil {
+				return "", err
+			}
+		}
+		targetKey = cacheKey
 	}
 
 	return targetKey, nil
 }
+
+// getUnpinnedCacheKey 去掉置顶帖子的社区排行缓存的 key，包含置顶帖子列表的摘要，
+// 置顶或取消置顶之后置顶列表不同，自然会使用新的 key，无需主动清理
+func getUnpinnedCacheKey(commID int64, pinnedIDs []int64, baseKey string) string {
+	return getRedisKey(KeyUnpinnedCachePF + strconv.FormatInt(commID, 10) + ":" +
+		hashIDs(pinnedIDs) + ":" + strings.TrimPrefix(baseKey, Prefix))
+}
+
+/*
+genUnpinnedPostKey 社区中有置顶帖子时使用的排行：社区排行缓存去掉置顶的帖子
+
+置顶的帖子由 service 放在第一页的最前面，排行中去掉它们之后，page/size 和游标翻页都不会再次返回置顶的帖子
+*/
+func genUnpinnedPostKey(ctx context.Context, commID int64, pinnedIDs []int64, baseKey string) (targetKey string, err error) {
+	cacheKey := getUnpinnedCacheKey(commID, pinnedIDs, baseKey)
+	hit, err := hitPostCache(ctx, cacheKey)
+	if err != nil {
+		return "", err
+	}
+	if hit {
+		return cacheKey, nil
+	}
+
+	communityKey, err := genCommunityPostKey(ctx, commID, baseKey)
+	if err != nil {
+		return "", err
+	}
+
+	members := make([]interface{}, 0, len(pinnedIDs))
+	for _, id := range pinnedIDs {
+		members = append(members, postMember(strconv.FormatInt(id, 10)))
+	}
+	pipe := client.TxPipeline()
+	pipe.ZUnionStore(ctx, cacheKey, &redis.ZStore{Keys: []string{communityKey}})
+	pipe.ZRem(ctx, cacheKey, members...)
+	pipe.Expire(ctx, cacheKey, communityPostCacheTTL)
+	countCmd := pipe.ZCard(ctx, cacheKey)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	if countCmd.Val() == 0 {
+		if err = markEmptyPostCache(ctx, cacheKey); err != nil {
+			return "", err
+		}
+	}
+	return cacheKey, nil
+}
+
+// hashIDs 计算 id 列表的摘要，与 id 的顺序无关
+func hashIDs(ids []int64) string {
+	sorted := make([]int64, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	h := fnv.New64a()
+	for _, id := range sorted {
+		_, _ = h.Write([]byte(strconv.FormatInt(id, 10) + ","))
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// getFeedCacheKey 首页 feed 缓存的 key，由排序后的社区 id 列表计算而来，订阅相同社区的用户共享同一份缓存，
+// 订阅发生变化后社区列表不同，自然会使用新的 key
+func getFeedCacheKey(communityIDs []int64, baseKey string) string {
+	return getRedisKey(KeyFeedCachePF + hashIDs(communityIDs) + ":" + strings.TrimPrefix(baseKey, Prefix))
+}
+
+/*
+genFeedKey 合并多个社区的帖子排行，作为首页 feed
+
+先取得每个社区的排行缓存（社区 Set 与时间榜/热度榜的交集），再用 ZUnionStore 合并，
+合并结果与社区排行缓存一样只保留一小段时间
+*/
+func genFeedKey(ctx context.Context, communityIDs []int64, baseKey string) (targetKey string, err error) {
+	cacheKey := getFeedCacheKey(communityIDs, baseKey)
+	hit, err := hitPostCache(ctx, cacheKey)
+	if err != nil {
+		return "", err
+	}
+	if hit {
+		return cacheKey, nil
+	}
+
+	keys := make([]string, 0, len(communityIDs))
+	for _, id := range communityIDs {
+		key, err := genCommunityPostKey(ctx, id, baseKey)
+		if err != nil {
+			return "", err
+		}
+		keys = append(keys, key)
+	}
+
+	// 一个帖子只属于一个社区，各个社区排行之间没有交集，AGGREGATE 取什么都不影响分数
+	pipe := client.TxPipeline()
+	countCmd := pipe.ZUnionStore(ctx, cacheKey, &redis.ZStore{
+		Keys:      keys,
+		Aggregate: "MAX",
+	})
+	pipe.Expire(ctx, cacheKey, communityPostCacheTTL)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	if countCmd.Val() == 0 {
+		if err = markEmptyPostCache(ctx, cacheKey); err != nil {
+			return "", err
+		}
+	}
+	return cacheKey, nil
+}
@@ -0,0 +1,221 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/namelyzz/sayit/models"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPostIDsInOrder_Cursor(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	// 20 个帖子，每 4 个的发帖时间相同，用来验证同分帖子跨页时不重复、不遗漏
+	for i := 0; i < 20; i++ {
+		postID := strconv.Itoa(1000 + i)
+		addPost(t, postID, int64(1700000000+i/4))
+	}
+
+	for _, order := range []models.SortDirection{models.SortDirectionDesc, models.SortDirectionAsc} {
+		p := &models.ParamPostList{
+			SortBy: models.SortFieldCreateTime,
+			Order:  order,
+			Page:   1,
+			Size:   3,
+		}
+
+		// 不带游标时按排名取出全部帖子，作为期望的顺序
+		all := &models.ParamPostList{SortBy: p.SortBy, Order: order, Page: 1, Size: 20}
+		want, err := GetPostIDsInOrder(ctx, all)
+		require.NoError(t, err)
+		require.Len(t, want, 20)
+
+		var got []redis.Z
+		for {
+			page, err := GetPostIDsInOrder(ctx, p)
+			require.NoError(t, err)
+			got = append(got, page...)
+			if len(page) < p.Size {
+				break
+			}
+
+			last := page[len(page)-1]
+			lastID, err := strconv.ParseInt(last.Member.(string), 10, 64)
+			require.NoError(t, err)
+			p.After = &models.PostCursor{SortBy: p.SortBy, Order: order, Value: last.Score, PostID: lastID}
+		}
+
+		assert.Equal(t, want, got, "order: %s", order)
+	}
+}
+
+func TestGetPostIDsInOrder_TieBreakByID(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	// 同一秒发布、位数不同的帖子 ID，字典序与数值顺序不一致
+	ids := []string{"9", "80", "700", "1000", "11", "12000"}
+	for _, id := range ids {
+		addPost(t, id, 1700000000)
+	}
+	addPost(t, "5", 1700000001)
+
+	wantDesc := []string{"5", "12000", "1000", "700", "80", "11", "9"}
+	wantAsc := []string{"9", "11", "80", "700", "1000", "12000", "5"}
+	for order, want := range map[models.SortDirection][]string{
+		models.SortDirectionDesc: wantDesc,
+		models.SortDirectionAsc:  wantAsc,
+	} {
+		// page/size 翻页
+		var byPage []string
+		for page := 1; page <= 3; page++ {
+			zs, err := GetPostIDsInOrder(ctx, &models.ParamPostList{
+				SortBy: models.SortFieldCreateTime, Order: order, Page: page, Size: 3,
+			})
+			require.NoError(t, err)
+			for _, z := range zs {
+				byPage = append(byPage, z.Member.(string))
+			}
+		}
+		assert.Equal(t, want, byPage, "order: %s", order)
+
+		// 用 MySQL 按 post_id 数值排序发出的游标继续翻页
+		p := &models.ParamPostList{
+			SortBy: models.SortFieldCreateTime, Order: order, Page: 1, Size: 10,
+			After: &models.PostCursor{SortBy: models.SortFieldCreateTime, Order: order, Value: 1700000000, PostID: 700},
+		}
+		zs, err := GetPostIDsInOrder(ctx, p)
+		require.NoError(t, err)
+		var afterCursor []string
+		for _, z := range zs {
+			afterCursor = append(afterCursor, z.Member.(string))
+		}
+		i := 0
+		for want[i] != "700" {
+			i++
+		}
+		assert.Equal(t, want[i+1:], afterCursor, "order: %s", order)
+	}
+
+	// 游标对应的帖子已被删除时，从它原来的位置继续
+	zs, err := GetPostIDsInOrder(ctx, &models.ParamPostList{
+		SortBy: models.SortFieldCreateTime, Order: models.SortDirectionDesc, Page: 1, Size: 3,
+		After: &models.PostCursor{SortBy: models.SortFieldCreateTime, Order: models.SortDirectionDesc, Value: 1700000000, PostID: 750},
+	})
+	require.NoError(t, err)
+	var afterDeleted []string
+	for _, z := range zs {
+		afterDeleted = append(afterDeleted, z.Member.(string))
+	}
+	assert.Equal(t, []string{"700", "80", "11"}, afterDeleted)
+}
+
+func TestGetPostIDsInOrder_Feed(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	// 帖子 i 属于社区 i%3 + 1，只订阅社区 1 和 2
+	for i := 0; i < 9; i++ {
+		require.NoError(t, CreatePost(ctx, int64(100+i), int64(i%3+1), float64(1700000000+i), nil))
+	}
+
+	p := &models.ParamPostList{
+		SortBy:       models.SortFieldCreateTime,
+		Order:        models.SortDirectionDesc,
+		Page:         1,
+		Size:         10,
+		CommunityIDs: []int64{2, 1},
+	}
+	zs, err := GetPostIDsInOrder(ctx, p)
+	require.NoError(t, err)
+
+	var got []string
+	for _, z := range zs {
+		got = append(got, z.Member.(string))
+	}
+	assert.Equal(t, []string{"107", "106", "104", "103", "101", "100"}, got)
+
+	// 社区顺序不同时使用同一份缓存
+	p.CommunityIDs = []int64{1, 2}
+	assert.Equal(t,
+		getFeedCacheKey([]int64{2, 1}, getRedisKey(KeyPostTimeZset)),
+		getFeedCacheKey(p.CommunityIDs, getRedisKey(KeyPostTimeZset)))
+}
+
+func TestGetPostIDsInOrder_ExcludePinned(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	// 社区 1 中的帖子 100~105，社区 2 中的帖子 200
+	for i := 0; i < 6; i++ {
+		require.NoError(t, CreatePost(ctx, int64(100+i), 1, float64(1700000000+i), nil))
+	}
+	require.NoError(t, CreatePost(ctx, 200, 2, 1700000100, nil))
+
+	list := func(pinnedIDs []int64) []string {
+		p := &models.ParamPostList{
+			SortBy:      models.SortFieldCreateTime,
+			Order:       models.SortDirectionDesc,
+			Page:        1,
+			Size:        3,
+			CommunityID: 1,
+			PinnedIDs:   pinnedIDs,
+		}
+		var ids []string
+		for {
+			zs, err := GetPostIDsInOrder(ctx, p)
+			require.NoError(t, err)
+			for _, z := range zs {
+				ids = append(ids, z.Member.(string))
+			}
+			if len(zs) < p.Size {
+				return ids
+			}
+			last := zs[len(zs)-1]
+			p.After = &models.PostCursor{SortBy: p.SortBy, Order: p.Order, Value: last.Score, PostID: memberID(last)}
+		}
+	}
+
+	assert.Equal(t, []string{"105", "104", "103", "102", "101", "100"}, list(nil))
+	// 置顶的帖子由 service 放在第一页最前面，排行中不再出现
+	assert.Equal(t, []string{"105", "103", "102", "100"}, list([]int64{104, 101}))
+	// 置顶列表变化后使用新的缓存
+	assert.Equal(t, []string{"105", "104", "103", "102", "100"}, list([]int64{101}))
+}
+
+func TestGenCommunityPostKey_Cache(t *testing.T) {
+	mr := setupMiniRedis(t)
+	ctx := context.Background()
+	baseKey := getRedisKey(KeyPostTimeZset)
+
+	require.NoError(t, CreatePost(ctx, 100, 1, 1700000000, nil))
+
+	// 命中时剩余有效期不足 postCacheReadGrace 的缓存会被延长，调用方读取之前不会过期
+	cacheKey, err := genCommunityPostKey(ctx, 1, baseKey)
+	require.NoError(t, err)
+	mr.FastForward(communityPostCacheTTL - time.Second)
+	_, err = genCommunityPostKey(ctx, 1, baseKey)
+	require.NoError(t, err)
+	assert.Equal(t, postCacheReadGrace, mr.TTL(cacheKey))
+
+	// 没有帖子的社区缓存空结果，有效期内不再重复计算
+	emptyKey, err := genCommunityPostKey(ctx, 2, baseKey)
+	require.NoError(t, err)
+	assert.True(t, mr.Exists(emptyKey+emptyPostCacheSuffix))
+
+	require.NoError(t, CreatePost(ctx, 200, 2, 1700000100, nil))
+	_, err = genCommunityPostKey(ctx, 2, baseKey)
+	require.NoError(t, err)
+	assert.Zero(t, client.ZCard(ctx, emptyKey).Val())
+
+	mr.FastForward(communityPostCacheTTL)
+	_, err = genCommunityPostKey(ctx, 2, baseKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), client.ZCard(ctx, emptyKey).Val())
+}
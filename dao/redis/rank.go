@@ -0,0 +1,173 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/namelyzz/sayit/models"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// maxRankUpdateRetries 更新排行榜时票数被并发修改的最大重试次数
+const maxRankUpdateRetries = 3
+
+// RankScoresFunc 根据票数和发帖时间计算帖子在各排序策略下的分数
+type RankScoresFunc func(up, down, createTime int64) map[models.SortField]float64
+
+// getRankRebuildKey 排序策略正在重建的排行榜
+func getRankRebuildKey(sortBy models.SortField) string {
+	return getRedisKey(KeyRankRebuildPF + string(sortBy))
+}
+
+// GetPostCreateTime 从时间榜中获取帖子的发帖时间，帖子不在时间榜中时返回 redis.Nil
+func GetPostCreateTime(ctx context.Context, postID string) (int64, error) {
+	createTime, err := client.ZScore(ctx, getRedisKey(KeyPostTimeZset), postMember(postID)).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int64(createTime), nil
+}
+
+/*
+UpdatePostRanks 读取帖子最新的票数，用 scores 计算分数后写入各排序策略的排行榜
+
+用 WATCH 监视帖子的投票记录：读取票数之后、写入之前有新的投票，事务会被放弃并重新读取，
+保证最后写入的分数对应最新的票数，并发投票不会留下旧的分数。
+帖子不在时间榜中（已被删除）时不做任何修改
+*/
+func UpdatePostRanks(ctx context.Context, postID string, scores RankScoresFunc) error {
+	votedKey := getRedisKey(KeyPostVotedZsetPF + postID)
+
+	txf := func(tx *redis.Tx) error {
+		pipe := tx.Pipeline()
+		upCmd := pipe.ZCount(ctx, votedKey, "1", "1")
+		downCmd := pipe.ZCount(ctx, votedKey, "-1", "-1")
+		timeCmd := pipe.ZScore(ctx, getRedisKey(KeyPostTimeZset), postMember(postID))
+		if _, err := pipe.Exec(ctx); err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil
+			}
+			return err
+		}
+
+		ranks := scores(upCmd.Val(), downCmd.Val(), int64(timeCmd.Val()))
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			setRanks(ctx, pipe, postID, ranks)
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < maxRankUpdateRetries; i++ {
+		if err = client.Watch(ctx, txf, votedKey); !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+// SetPostRanks 按给定的分数更新帖子在各排序策略排行榜中的分数，用于投票期结束后按归档的票数写入
+func SetPostRanks(ctx context.Context, postID string, ranks map[models.SortField]float64) error {
+	pipe := client.Pipeline()
+	setRanks(ctx, pipe, postID, ranks)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+/*
+setRanks 写入帖子在各排行榜中的分数
+
+使用 ZADD XX 只更新已在排行榜中的帖子，不会把刚被删除的帖子重新加回去；
+正在重建的排行榜同样更新，避免重建完成替换线上排行榜时丢失重建期间的投票
+*/
+func setRanks(ctx context.Context, pipe redis.Pipeliner, postID string, ranks map[models.SortField]float64) {
+	for sortBy, rank := range ranks {
+		z := redis.Z{Score: rank, Member: postMember(postID)}
+		pipe.ZAddXX(ctx, getRankKey(sortBy), z)
+		pipe.ZAddXX(ctx, getRankRebuildKey(sortBy), z)
+	}
+}
+
+// AcquireRankRebuildLock 获取重建排行榜的锁，已被其他实例持有时返回 false
+func AcquireRankRebuildLock(ctx context.Context, ttl time.Duration) (bool, error) {
+	return client.SetNX(ctx, getRedisKey(KeyRankRebuildLock), 1, ttl).Result()
+}
+
+// ReleaseRankRebuildLock 释放重建排行榜的锁
+func ReleaseRankRebuildLock(ctx context.Context) error {
+	return client.Del(ctx, getRedisKey(KeyRankRebuildLock)).Err()
+}
+
+// StartRankRebuild 开始重建排行榜，清除上次重建中途失败留下的数据
+func StartRankRebuild(ctx context.Context, sortBy models.SortField) error {
+	return client.Del(ctx, getRankRebuildKey(sortBy)).Err()
+}
+
+// AddRankRebuildPosts 将一批帖子的分数写入正在重建的排行榜，线上的排行榜不受影响，zs 的 Member 为帖子 ID
+func AddRankRebuildPosts(ctx context.Context, sortBy models.SortField, zs []redis.Z) error {
+	if len(zs) == 0 {
+		return nil
+	}
+	members := make([]redis.Z, 0, len(zs))
+	for _, z := range zs {
+		members = append(members, redis.Z{Score: z.Score, Member: postMember(fmt.Sprint(z.Member))})
+	}
+	return client.ZAdd(ctx, getRankRebuildKey(sortBy), members...).Err()
+}
+
+/*
+finishRankRebuildScript 用重建好的排行榜原子地替换线上的排行榜
+
+重建期间仍在发帖、删帖，替换前先修正：
+ 1. 只保留仍在时间榜中的帖子，去掉重建期间被删除的帖子
+ 2. 发帖时间不早于 ARGV[1] 的帖子，重建时可能还没有写入 MySQL，从线上的排行榜中补上
+ 3. RENAME 为线上的排行榜，没有任何帖子时直接删除线上的排行榜
+
+KEYS[1]: rank:rebuild:<sortBy>   KEYS[2]: post:rank:<sortBy>   KEYS[3]: post:time
+ARGV[1]: 重建开始的时间戳
+*/
+var finishRankRebuildScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('ZINTERSTORE', KEYS[1], 2, KEYS[1], KEYS[3], 'WEIGHTS', 1, 0)
+end
+
+local recent = redis.call('ZRANGEBYSCORE', KEYS[3], ARGV[1], '+inf')
+for _, postID in ipairs(recent) do
+	if not redis.call('ZSCORE', KEYS[1], postID) then
+		local rank = redis.call('ZSCORE', KEYS[2], postID)
+		if rank then
+			redis.call('ZADD', KEYS[1], rank, postID)
+		end
+	end
+end
+
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('RENAME', KEYS[1], KEYS[2])
+else
+	redis.call('DEL', KEYS[2])
+end
+return 0
+`)
+
+// FinishRankRebuild 用重建好的排行榜替换线上的排行榜，since 为重建开始的时间戳
+func FinishRankRebuild(ctx context.Context, sortBy models.SortField, since int64) error {
+	keys := []string{getRankRebuildKey(sortBy), getRankKey(sortBy), getRedisKey(KeyPostTimeZset)}
+	return finishRankRebuildScript.Run(ctx, client, keys, strconv.FormatInt(since, 10)).Err()
+}
+
+// GetRankVersion 获取排行榜当前所用公式的版本，从未构建过时返回空字符串
+func GetRankVersion(ctx context.Context, sortBy models.SortField) (string, error) {
+	version, err := client.HGet(ctx, getRedisKey(KeyRankVersionHash), string(sortBy)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return version, err
+}
+
+// SetRankVersion 记录排行榜重建完成后所用公式的版本
+func SetRankVersion(ctx context.Context, sortBy models.SortField, version string) error {
+	return client.HSet(ctx, getRedisKey(KeyRankVersionHash), string(sortBy), version).Err()
+}
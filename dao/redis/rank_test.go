@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/namelyzz/sayit/models"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// netVotes 测试用的排序分数：赞成票数减反对票数
+func netVotes(up, down, createTime int64) map[models.SortField]float64 {
+	return map[models.SortField]float64{models.SortFieldHot: float64(up - down)}
+}
+
+func rankScore(t *testing.T, postID string) (float64, bool) {
+	score, err := client.ZScore(context.Background(), getRankKey(models.SortFieldHot), postMember(postID)).Result()
+	if err == redis.Nil {
+		return 0, false
+	}
+	require.NoError(t, err)
+	return score, true
+}
+
+func TestUpdatePostRanks(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	require.NoError(t, CreatePost(ctx, 1, 1, float64(now), netVotes(0, 0, now)))
+	for _, userID := range []string{"10", "11", "12"} {
+		_, err := VoteForPost(ctx, userID, "1", 1)
+		require.NoError(t, err)
+	}
+	_, err := VoteForPost(ctx, "13", "1", -1)
+	require.NoError(t, err)
+
+	require.NoError(t, UpdatePostRanks(ctx, "1", netVotes))
+	score, ok := rankScore(t, "1")
+	require.True(t, ok)
+	assert.Equal(t, float64(2), score)
+
+	// 已被删除的帖子不会被重新加回排行榜
+	require.NoError(t, DeletePost(ctx, 1, 1))
+	require.NoError(t, UpdatePostRanks(ctx, "1", netVotes))
+	_, ok = rankScore(t, "1")
+	assert.False(t, ok)
+}
+
+func TestRankRebuild(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	// 重建开始前的帖子 1、2、3，线上排行榜是旧公式的分数
+	for _, id := range []int64{1, 2, 3} {
+		require.NoError(t, CreatePost(ctx, id, 1, float64(1000+id), map[models.SortField]float64{models.SortFieldHot: -1}))
+	}
+	require.NoError(t, StartRankRebuild(ctx, models.SortFieldHot))
+	require.NoError(t, AddRankRebuildPosts(ctx, models.SortFieldHot, []redis.Z{
+		{Score: 10, Member: "1"}, {Score: 20, Member: "2"}, {Score: 30, Member: "3"},
+	}))
+
+	// 重建期间：线上排行榜保持不变；帖子 2 被删除；帖子 4 发布；帖子 1 的分数被投票更新
+	score, _ := rankScore(t, "1")
+	assert.Equal(t, float64(-1), score)
+	require.NoError(t, DeletePost(ctx, 2, 1))
+	require.NoError(t, CreatePost(ctx, 4, 1, 2000, map[models.SortField]float64{models.SortFieldHot: 40}))
+	require.NoError(t, SetPostRanks(ctx, "1", map[models.SortField]float64{models.SortFieldHot: 15}))
+
+	require.NoError(t, FinishRankRebuild(ctx, models.SortFieldHot, 2000))
+
+	zs, err := client.ZRevRangeWithScores(ctx, getRankKey(models.SortFieldHot), 0, -1).Result()
+	require.NoError(t, err)
+	decodePostMembers(zs)
+	assert.Equal(t, []redis.Z{
+		{Score: 40, Member: "4"}, {Score: 30, Member: "3"}, {Score: 15, Member: "1"},
+	}, zs)
+
+	exists, err := client.Exists(ctx, getRankRebuildKey(models.SortFieldHot)).Result()
+	require.NoError(t, err)
+	assert.Zero(t, exists)
+}
+
+func TestGetPostIDsInOrder_ZeroScoreGroup(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	// controversial 中没有反对票的帖子分数都是 0，只有帖子 1 有分数
+	for i := 1; i <= 50; i++ {
+		rank := 0.0
+		if i == 1 {
+			rank = 2
+		}
+		require.NoError(t, CreatePost(ctx, int64(i), 1, float64(time.Now().Unix()),
+			map[models.SortField]float64{models.SortFieldControversial: rank}))
+	}
+
+	p := &models.ParamPostList{
+		SortBy: models.SortFieldControversial,
+		Order:  models.SortDirectionDesc,
+		Page:   1,
+		Size:   7,
+	}
+	var got []int64
+	for {
+		zs, err := GetPostIDsInOrder(ctx, p)
+		require.NoError(t, err)
+		for _, z := range zs {
+			got = append(got, memberID(z))
+		}
+		if len(zs) < p.Size {
+			break
+		}
+		last := zs[len(zs)-1]
+		p.After = &models.PostCursor{SortBy: p.SortBy, Order: p.Order, Value: last.Score, PostID: memberID(last)}
+	}
+
+	// 有分数的帖子在前，其余同分的帖子按帖子 ID 倒序，即新帖子在前
+	want := []int64{1}
+	for i := 50; i >= 2; i-- {
+		want = append(want, int64(i))
+	}
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+// RateLimitResult 一次限流检查的结果
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int           // 窗口内还能发出的请求数
+	ResetAfter time.Duration // 窗口内最早的一次请求移出窗口、空出名额所需的时间
+}
+
+/*
+rateLimitScript 滑动窗口限流：zset 中保存窗口内每次请求的时间，
+先移除窗口外的请求，再根据剩余的请求数决定是否放行，放行时记录本次请求
+
+与固定窗口相比，不会在窗口边界前后各放行一整个窗口的请求
+
+KEYS[1]: ratelimit:<group>:<subject>
+ARGV[1]: 当前时间（毫秒）   ARGV[2]: 窗口长度（毫秒）   ARGV[3]: 窗口内允许的请求数   ARGV[4]: 本次请求的唯一标识
+返回 {是否放行 1/0, 剩余请求数, 空出名额所需的毫秒数}
+*/
+var rateLimitScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {allowed, limit - count, tonumber(oldest[2]) + window - now}
+`)
+
+// AllowRequest 检查 subject 在路由组 group 中的请求是否超过 window 内 limit 次的限制，未超过时计入本次请求
+func AllowRequest(ctx context.Context, group, subject string, limit int, window time.Duration) (*RateLimitResult, error) {
+	now := time.Now().UnixMilli()
+	member := strconv.FormatInt(now, 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	res, err := rateLimitScript.Run(ctx, client, []string{getRedisKey(KeyRateLimitPF + group + ":" + subject)},
+		now, window.Milliseconds(), limit, member).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RateLimitResult{
+		Allowed:    res[0] == 1,
+		Remaining:  int(res[1]),
+		ResetAfter: time.Duration(res[2]) * time.Millisecond,
+	}, nil
+}
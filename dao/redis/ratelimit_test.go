@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowRequest(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+	window := 100 * time.Millisecond
+
+	for i := 1; i >= 0; i-- {
+		res, err := AllowRequest(ctx, "vote", "user:1", 2, window)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, i, res.Remaining)
+	}
+
+	res, err := AllowRequest(ctx, "vote", "user:1", 2, window)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.True(t, res.ResetAfter > 0 && res.ResetAfter <= window)
+
+	// 其他用户、其他路由组的限额互不影响
+	res, err = AllowRequest(ctx, "vote", "user:2", 2, window)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = AllowRequest(ctx, "post", "user:1", 2, window)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	// 窗口滑过之后重新放行
+	time.Sleep(window + 10*time.Millisecond)
+	res, err = AllowRequest(ctx, "vote", "user:1", 2, window)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+}
@@ -0,0 +1,293 @@
+package redis
+
+import (
+	"context"
+	"github.com/namelyzz/sayit/models"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PostIndexState 帖子在 Redis 各个索引中是否存在
+type PostIndexState struct {
+	InTime      bool // 在时间榜中
+	InScore     bool // 在热度榜中
+	InCommunity bool // 在所属社区的集合中
+	HasVoted    bool // 有投票记录
+}
+
+// GetPostIndexStates 批量检查帖子在 Redis 各个索引中是否存在，结果与 posts 一一对应
+func GetPostIndexStates(ctx context.Context, posts []*models.Post) ([]*PostIndexState, error) {
+	pipe := client.Pipeline()
+
+	timeCmds := make([]*redis.FloatCmd, len(posts))
+	scoreCmds := make([]*redis.FloatCmd, len(posts))
+	commCmds := make([]*redis.BoolCmd, len(posts))
+	votedCmds := make([]*redis.IntCmd, len(posts))
+	for i, post := range posts {
+		postID := strconv.FormatInt(post.PostID, 10)
+		member := postMember(postID)
+		timeCmds[i] = pipe.ZScore(ctx, getRedisKey(KeyPostTimeZset), member)
+		scoreCmds[i] = pipe.ZScore(ctx, getRedisKey(KeyPostScoreZset), member)
+		commCmds[i] = pipe.SIsMember(ctx, getRedisKey(KeyCommunitySetPF+strconv.FormatInt(post.CommunityID, 10)), member)
+		votedCmds[i] = pipe.Exists(ctx, getRedisKey(KeyPostVotedZsetPF+postID))
+	}
+
+	// 帖子不在 zset 中时 ZScore 返回 redis.Nil，不算错误
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	res := make([]*PostIndexState, len(posts))
+	for i := range posts {
+		res[i] = &PostIndexState{
+			InTime:      timeCmds[i].Err() == nil,
+			InScore:     scoreCmds[i].Err() == nil,
+			InCommunity: commCmds[i].Val(),
+			HasVoted:    votedCmds[i].Val() > 0,
+		}
+	}
+	return res, nil
+}
+
+/*
+RestorePosts 将帖子重新写入时间榜、热度榜、各排序策略的排行榜以及所属社区
+
+ranks 与 posts 一一对应。排行榜使用 ZADD NX，只补写缺失的帖子，
+已存在的分数包含了投票带来的实时变化，比 MySQL 中的数据更新，不能覆盖
+*/
+func RestorePosts(ctx context.Context, posts []*models.Post, ranks []map[models.SortField]float64) error {
+	pipe := client.Pipeline()
+	for i, post := range posts {
+		postID := postMember(strconv.FormatInt(post.PostID, 10))
+
+		pipe.ZAddNX(ctx, getRedisKey(KeyPostTimeZset), redis.Z{
+			Score:  float64(post.CreateTime.Unix()),
+			Member: postID,
+		})
+		pipe.ZAddNX(ctx, getRedisKey(KeyPostScoreZset), redis.Z{
+			Score:  post.Score,
+			Member: postID,
+		})
+		for sortBy, rank := range ranks[i] {
+			pipe.ZAddNX(ctx, getRankKey(sortBy), redis.Z{
+				Score:  rank,
+				Member: postID,
+			})
+		}
+		pipe.SAdd(ctx, getRedisKey(KeyCommunitySetPF+strconv.FormatInt(post.CommunityID, 10)), postID)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// PostZsetKeys 保存帖子的全部 zset：时间榜、热度榜和各排序策略的排行榜
+func PostZsetKeys() []string {
+	keys := []string{getRedisKey(KeyPostTimeZset), getRedisKey(KeyPostScoreZset)}
+	for _, sortBy := range models.RankSortFields {
+		keys = append(keys, getRankKey(sortBy))
+	}
+	return keys
+}
+
+// ScanZsetPostIDs 用 ZSCAN 遍历 zset 中的帖子 id，cursor 为 0 表示从头开始，返回的 next 为 0 表示遍历结束
+func ScanZsetPostIDs(ctx context.Context, key string, cursor uint64, count int64) (postIDs []string, next uint64, err error) {
+	// ZSCAN 返回的结果中 member 和 score 交替出现
+	kvs, next, err := client.ZScan(ctx, key, cursor, "", count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	postIDs = make([]string, 0, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		postIDs = append(postIDs, postIDOfMember(kvs[i]))
+	}
+	return postIDs, next, nil
+}
+
+// ScanCommunityPostIDs 用 SSCAN 遍历社区集合中的帖子 id，用法与 ScanZsetPostIDs 相同
+func ScanCommunityPostIDs(ctx context.Context, communityID int64, cursor uint64, count int64) (postIDs []string, next uint64, err error) {
+	key := getRedisKey(KeyCommunitySetPF + strconv.FormatInt(communityID, 10))
+	members, next, err := client.SScan(ctx, key, cursor, "", count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	postIDs = make([]string, 0, len(members))
+	for _, member := range members {
+		postIDs = append(postIDs, postIDOfMember(member))
+	}
+	return postIDs, next, nil
+}
+
+// postMembersOf 帖子 ID 对应的 member，同时包含没有补零的旧格式，用于移除
+func postMembersOf(postIDs []string) []interface{} {
+	members := make([]interface{}, 0, 2*len(postIDs))
+	for _, id := range postIDs {
+		members = append(members, postMember(id))
+		if isLegacyPostMember(id) {
+			members = append(members, id)
+		}
+	}
+	return members
+}
+
+/*
+RemoveOrphanedPosts 将 MySQL 中已不存在或已删除的帖子从时间榜、热度榜和各排序策略的排行榜中移除。
+移出时间榜之后不再接受投票，投票记录由调用方归档之后用 DeletePostVotes 清理；
+帖子所属的社区无法从 Redis 得知，社区集合由 RemovePostsFromCommunity 单独清理
+*/
+func RemoveOrphanedPosts(ctx context.Context, postIDs []string) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+
+	members := postMembersOf(postIDs)
+	pipe := client.Pipeline()
+	for _, key := range PostZsetKeys() {
+		pipe.ZRem(ctx, key, members...)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RemovePostsFromCommunity 将帖子从社区集合中移除
+func RemovePostsFromCommunity(ctx context.Context, communityID int64, postIDs []string) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+
+	return client.SRem(ctx, getRedisKey(KeyCommunitySetPF+strconv.FormatInt(communityID, 10)), postMembersOf(postIDs)...).Err()
+}
+
+// postMemberVersion 当前 member 的格式版本：补零到固定位数的帖子 ID
+const postMemberVersion = "2"
+
+// IsPostMemberMigrated 判断排行榜和社区集合中的 member 是否已全部转换为补零的格式
+func IsPostMemberMigrated(ctx context.Context) (bool, error) {
+	version, err := client.Get(ctx, getRedisKey(KeyPostMemberVersion)).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return version == postMemberVersion, err
+}
+
+// SetPostMemberMigrated 记录 member 已全部转换为补零的格式
+func SetPostMemberMigrated(ctx context.Context) error {
+	return client.Set(ctx, getRedisKey(KeyPostMemberVersion), postMemberVersion, 0).Err()
+}
+
+/*
+migratePostMemberScript 将一个帖子在全部 zset 中的旧格式 member 原子地改为补零的格式，保留原有的分数
+
+同一个帖子的各个 zset 一起转换，转换期间投票脚本不会看到只转换了一半的帖子。
+补零的 member 已经存在时以它为准，只删除旧格式的 member
+
+KEYS: PostZsetKeys()   ARGV[1]: 旧格式的 member   ARGV[2]: 补零的 member
+*/
+var migratePostMemberScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	local score = redis.call('ZSCORE', key, ARGV[1])
+	if score then
+		redis.call('ZADD', key, 'NX', score, ARGV[2])
+		redis.call('ZREM', key, ARGV[1])
+	end
+end
+return 0
+`)
+
+// MigrateZsetPostMembers 转换 zset 中一批旧格式的 member，用法与 ScanZsetPostIDs 相同
+func MigrateZsetPostMembers(ctx context.Context, key string, cursor uint64, count int64) (migrated int64, next uint64, err error) {
+	kvs, next, err := client.ZScan(ctx, key, cursor, "", count).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	keys := PostZsetKeys()
+	for i := 0; i < len(kvs); i += 2 {
+		if !isLegacyPostMember(kvs[i]) {
+			continue
+		}
+		if err = migratePostMemberScript.Run(ctx, client, keys, kvs[i], postMember(kvs[i])).Err(); err != nil {
+			return migrated, 0, err
+		}
+		migrated++
+	}
+	return migrated, next, nil
+}
+
+// MigrateCommunityPostMembers 转换社区集合中一批旧格式的 member，用法与 ScanCommunityPostIDs 相同
+func MigrateCommunityPostMembers(ctx context.Context, communityID int64, cursor uint64, count int64) (migrated int64, next uint64, err error) {
+	key := getRedisKey(KeyCommunitySetPF + strconv.FormatInt(communityID, 10))
+	members, next, err := client.SScan(ctx, key, cursor, "", count).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pipe := client.TxPipeline()
+	for _, member := range members {
+		if !isLegacyPostMember(member) {
+			continue
+		}
+		pipe.SAdd(ctx, key, postMember(member))
+		pipe.SRem(ctx, key, member)
+		migrated++
+	}
+	if migrated > 0 {
+		if _, err = pipe.Exec(ctx); err != nil {
+			return 0, 0, err
+		}
+	}
+	return migrated, next, nil
+}
+
+/*
+BackfillUserVotes 根据一批帖子的投票记录 post:voted:<id> 补写投票用户的投票历史 user:voted 和 user:vote_dir
+
+用于投票历史上线之前的投票，以及写入投票历史之前就存在的数据。post:voted 中没有投票时间，
+补写的记录以发帖时间作为投票时间；已存在的记录不会被覆盖。
+用 SCAN 遍历 post:voted:* 的 key，用法与 ScanZsetPostIDs 相同，restored 为补写的记录数
+*/
+func BackfillUserVotes(ctx context.Context, cursor uint64, count int64) (restored int64, next uint64, err error) {
+	prefix := getRedisKey(KeyPostVotedZsetPF)
+	keys, next, err := client.Scan(ctx, cursor, prefix+"*", count).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, key := range keys {
+		postID := strings.TrimPrefix(key, prefix)
+		voters, err := client.ZRangeWithScores(ctx, key, 0, -1).Result()
+		if err != nil {
+			return restored, 0, err
+		}
+		if len(voters) == 0 {
+			continue
+		}
+
+		voteTime, err := client.ZScore(ctx, getRedisKey(KeyPostTimeZset), postMember(postID)).Result()
+		if errors.Is(err, redis.Nil) {
+			voteTime, err = float64(time.Now().Unix()), nil
+		}
+		if err != nil {
+			return restored, 0, err
+		}
+
+		pipe := client.Pipeline()
+		addCmds := make([]*redis.IntCmd, 0, len(voters))
+		for _, z := range voters {
+			userID := z.Member.(string)
+			addCmds = append(addCmds, pipe.ZAddNX(ctx, getRedisKey(KeyUserVotedZsetPF+userID), redis.Z{Score: voteTime, Member: postID}))
+			pipe.HSetNX(ctx, getRedisKey(KeyUserVoteDirHashPF+userID), postID, int64(z.Score))
+		}
+		if _, err = pipe.Exec(ctx); err != nil {
+			return restored, 0, err
+		}
+		for _, cmd := range addCmds {
+			restored += cmd.Val()
+		}
+	}
+	return restored, next, nil
+}
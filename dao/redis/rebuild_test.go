@@ -0,0 +1,179 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/namelyzz/sayit/models"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestorePosts(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	createTime := time.Unix(1700000000, 0)
+	posts := []*models.Post{
+		{PostID: 1, CommunityID: 1, Score: 1700000432, CreateTime: createTime},
+		{PostID: 2, CommunityID: 2, Score: 1700000000, CreateTime: createTime},
+	}
+	// 帖子 1 仍在 Redis 中，且投票后分数比 MySQL 中的新
+	require.NoError(t, CreatePost(ctx, 1, 1, 1700000000, nil))
+	require.NoError(t, client.ZIncrBy(ctx, getRedisKey(KeyPostScoreZset), 864, postMember("1")).Err())
+
+	states, err := GetPostIndexStates(ctx, posts)
+	require.NoError(t, err)
+	assert.Equal(t, &PostIndexState{InTime: true, InScore: true, InCommunity: true}, states[0])
+	assert.Equal(t, &PostIndexState{}, states[1])
+
+	ranks := []map[models.SortField]float64{
+		{models.SortFieldHot: 1},
+		{models.SortFieldHot: 2},
+	}
+	require.NoError(t, RestorePosts(ctx, posts, ranks))
+
+	states, err = GetPostIndexStates(ctx, posts)
+	require.NoError(t, err)
+	assert.True(t, states[1].InTime && states[1].InScore && states[1].InCommunity)
+
+	// 已存在的分数不会被 MySQL 中的旧数据覆盖
+	assert.Equal(t, float64(1700000864), postScore(t, "1"))
+	assert.Equal(t, float64(1700000000), postScore(t, "2"))
+
+	// 清理 MySQL 中已不存在的帖子
+	require.NoError(t, RemoveOrphanedPosts(ctx, []string{"2"}))
+	ids, _, err := ScanZsetPostIDs(ctx, getRedisKey(KeyPostTimeZset), 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, ids)
+}
+
+func TestRemoveOrphanedPosts(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	// 帖子 1 正常；帖子 2 只残留在热度榜、排序策略的排行榜、社区集合和投票记录中，投票记录要等归档后再清理
+	now := time.Now().Unix()
+	require.NoError(t, CreatePost(ctx, 1, 1, float64(now), nil))
+	require.NoError(t, CreatePost(ctx, 2, 1, float64(now), map[models.SortField]float64{models.SortFieldHot: 1}))
+	_, err := VoteForPost(ctx, "10", "2", 1)
+	require.NoError(t, err)
+	require.NoError(t, client.ZRem(ctx, getRedisKey(KeyPostTimeZset), postMember("2")).Err())
+
+	// 遍历全部 zset，而不只是时间榜
+	found := map[string]bool{}
+	for _, key := range PostZsetKeys() {
+		ids, _, err := ScanZsetPostIDs(ctx, key, 0, 10)
+		require.NoError(t, err)
+		for _, id := range ids {
+			found[id] = true
+		}
+	}
+	assert.Equal(t, map[string]bool{"1": true, "2": true}, found)
+
+	require.NoError(t, RemoveOrphanedPosts(ctx, []string{"2"}))
+	require.NoError(t, RemovePostsFromCommunity(ctx, 1, []string{"2"}))
+	require.NoError(t, DeletePostVotes(ctx, "2"))
+
+	for _, key := range PostZsetKeys() {
+		ids, _, err := ScanZsetPostIDs(ctx, key, 0, 10)
+		require.NoError(t, err)
+		assert.NotContains(t, ids, "2", key)
+	}
+	ids, _, err := ScanCommunityPostIDs(ctx, 1, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, ids)
+
+	votes, total, err := GetUserVotes(ctx, "10", 0, 10)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+	assert.Empty(t, votes)
+	exists, err := client.Exists(ctx, getRedisKey(KeyPostVotedZsetPF+"2"), getRedisKey(KeyVoteDirtySet)).Result()
+	require.NoError(t, err)
+	assert.Zero(t, exists)
+}
+
+func TestMigratePostMembers(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	// 旧格式的 member：没有补零的帖子 ID，热度榜中带有投票后的分数
+	for _, id := range []string{"9", "80"} {
+		z := redis.Z{Score: 1700000000, Member: id}
+		require.NoError(t, client.ZAdd(ctx, getRedisKey(KeyPostTimeZset), z).Err())
+		require.NoError(t, client.ZAdd(ctx, getRedisKey(KeyPostScoreZset), z).Err())
+		require.NoError(t, client.SAdd(ctx, getRedisKey(KeyCommunitySetPF+"1"), id).Err())
+	}
+	require.NoError(t, client.ZIncrBy(ctx, getRedisKey(KeyPostScoreZset), 432, "80").Err())
+	require.NoError(t, CreatePost(ctx, 700, 1, 1700000000, nil))
+
+	migrated, err := IsPostMemberMigrated(ctx)
+	require.NoError(t, err)
+	assert.False(t, migrated)
+
+	for _, key := range PostZsetKeys() {
+		_, next, err := MigrateZsetPostMembers(ctx, key, 0, 10)
+		require.NoError(t, err)
+		assert.Zero(t, next)
+	}
+	n, _, err := MigrateCommunityPostMembers(ctx, 1, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	require.NoError(t, SetPostMemberMigrated(ctx))
+
+	migrated, err = IsPostMemberMigrated(ctx)
+	require.NoError(t, err)
+	assert.True(t, migrated)
+	assert.Equal(t, float64(1700000432), postScore(t, "80"))
+
+	// 转换之后同分的帖子按帖子 ID 的数值排序，社区排行同样如此
+	for _, communityID := range []int64{0, 1} {
+		zs, err := GetPostIDsInOrder(ctx, &models.ParamPostList{
+			SortBy: models.SortFieldCreateTime, Order: models.SortDirectionDesc, Page: 1, Size: 10, CommunityID: communityID,
+		})
+		require.NoError(t, err)
+		var ids []string
+		for _, z := range zs {
+			ids = append(ids, z.Member.(string))
+		}
+		assert.Equal(t, []string{"700", "80", "9"}, ids)
+	}
+}
+
+func TestBackfillUserVotes(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	addPost(t, "1", 1700000000)
+	require.NoError(t, client.ZAdd(ctx, getRedisKey(KeyPostVotedZsetPF+"1"),
+		redis.Z{Score: 1, Member: "100"}, redis.Z{Score: -1, Member: "101"}).Err())
+	// 用户 101 已有的投票历史不会被覆盖
+	require.NoError(t, client.ZAdd(ctx, getRedisKey(KeyUserVotedZsetPF+"101"), redis.Z{Score: 1700000500, Member: "1"}).Err())
+	require.NoError(t, client.HSet(ctx, getRedisKey(KeyUserVoteDirHashPF+"101"), "1", -1).Err())
+
+	var restored int64
+	var cursor uint64
+	for {
+		n, next, err := BackfillUserVotes(ctx, cursor, 10)
+		require.NoError(t, err)
+		restored += n
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, int64(1), restored)
+
+	votes, total, err := GetUserVotes(ctx, "100", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, votes, 1)
+	assert.Equal(t, &UserVote{PostID: "1", Direction: 1, VoteTime: 1700000000}, votes[0])
+
+	votes, _, err = GetUserVotes(ctx, "101", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, votes, 1)
+	assert.Equal(t, &UserVote{PostID: "1", Direction: -1, VoteTime: 1700000500}, votes[0])
+}
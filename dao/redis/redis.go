@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/namelyzz/sayit/config"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -17,6 +18,32 @@ const (
 	KeyPostScoreZset   = "post:score"  // zset;帖子及其投票的分数
 	KeyPostVotedZsetPF = "post:voted:" // zset;记录用户及其投票类型
 	KeyCommunitySetPF  = "community:"  // set;保存每个分区下帖子的id
+
+	KeyPostMemberVersion = "post:member_version" // string;排行榜和社区集合中 member 的格式版本，见 postMember
+
+	KeyPostRankZsetPF  = "post:rank:"        // zset;按排序策略计算的帖子分数，如 post:rank:hot
+	KeyRankVersionHash = "rank:version"      // hash;每个排序策略当前排行榜所用公式的版本
+	KeyRankRebuildPF   = "rank:rebuild:"     // zset;正在重建的排行榜，如 rank:rebuild:hot，完成后 RENAME 为 post:rank:hot
+	KeyRankRebuildLock = "rank:rebuild_lock" // string;重建排行榜的锁，多个实例同时启动时只有一个执行重建
+
+	KeyUserVotedZsetPF   = "user:voted:"    // zset;用户投过票的帖子及投票时间
+	KeyUserVoteDirHashPF = "user:vote_dir:" // hash;用户对每个帖子的投票 1/-1，与 user:voted:<uid> 同步维护
+
+	KeyCommunityPostCachePF = "cache:community:"    // zset;社区帖子排行的短期缓存，社区 Set 与时间榜/热度榜的交集
+	KeyFeedCachePF          = "cache:feed:"         // zset;首页 feed 的短期缓存，多个社区排行缓存的并集
+	KeyUnpinnedCachePF      = "cache:unpinned:"     // zset;社区排行缓存去掉置顶帖子后的短期缓存
+	KeyVoteArchiveCursor    = "vote:archive:cursor" // string;投票归档进度，记录已归档帖子的最大发帖时间
+	KeyVoteDirtySet         = "vote:dirty"          // set;投票数据有变化、等待同步到 MySQL 的帖子id
+	KeyVoteSyncingZset      = "vote:syncing"        // zset;已从 vote:dirty 取出、正在同步的帖子id及取出时间，同步成功后才删除
+
+	KeyRefreshTokenPF     = "token:refresh:"      // hash;refresh token 对应的用户信息，key 中是 token 的 sha256，不保存 token 原文
+	KeyRevokedTokenPF     = "token:revoked:"      // string;已吊销的 access token 的 jti，过期时间与 token 一致
+	KeyUserTokenVersionPF = "user:token_version:" // string;用户的 token 版本号
+
+	KeyIdempotencyPF = "idempotency:" // string;幂等键对应的请求指纹和首次响应，key 为 idempotency:<userID>:<Idempotency-Key>
+	KeyRateLimitPF   = "ratelimit:"   // zset;滑动窗口内各次请求的时间（毫秒），key 为 ratelimit:<路由组>:<user:id 或 ip:地址>
+	KeyLoginFailPF   = "login:fail:"  // string;统计周期内登录失败的次数，key 为 login:fail:<user:用户名 或 ip:地址>
+	KeyLoginBlockPF  = "login:block:" // string;暂停登录的标记，过期时间即需要等待的时长，key 同上
 )
 
 func Init(cfg *config.RedisConfig) (err error) {
@@ -33,13 +60,20 @@ func Init(cfg *config.RedisConfig) (err error) {
 		return err
 	}
 
-	return nil
+	// 命令耗时和连接池状态，通过 /metrics 暴露
+	client.AddHook(metricsHook{})
+	return prometheus.Register(newPoolStatsCollector(client))
 }
 
 func Close() {
 	_ = client.Close()
 }
 
+// Ping 检查 Redis 连接是否可用
+func Ping(ctx context.Context) error {
+	return client.Ping(ctx).Err()
+}
+
 func getRedisKey(key string) string {
 	return Prefix + key
 }
@@ -0,0 +1,140 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// RefreshTokenData refresh token 中保存的用户信息
+type RefreshTokenData struct {
+	UserID       int64
+	Username     string
+	TokenVersion int64
+}
+
+// refreshTokenKey refresh token 对应的 key
+// key 中只保存 token 的 sha256，能读取 Redis 数据、执行 KEYS 或查看慢日志的人也拿不到可用的 refresh token
+func refreshTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return getRedisKey(KeyRefreshTokenPF + hex.EncodeToString(sum[:]))
+}
+
+// SaveRefreshToken 保存 refresh token，过期后自动删除
+func SaveRefreshToken(ctx context.Context, token string, data *RefreshTokenData, ttl time.Duration) error {
+	key := refreshTokenKey(token)
+
+	pipe := client.TxPipeline()
+	pipe.HSet(ctx, key,
+		"user_id", data.UserID,
+		"username", data.Username,
+		"version", data.TokenVersion,
+	)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// TakeRefreshToken 取出并删除 refresh token，token 不存在时返回 nil
+// 读取和删除在同一个事务中完成，同一个 refresh token 并发使用时只有一个请求能拿到数据，保证 token 只能用一次
+func TakeRefreshToken(ctx context.Context, token string) (*RefreshTokenData, error) {
+	key := refreshTokenKey(token)
+
+	pipe := client.TxPipeline()
+	getCmd := pipe.HGetAll(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return parseRefreshTokenData(getCmd.Val())
+}
+
+// DeleteRefreshToken 删除属于 userID 的 refresh token
+func DeleteRefreshToken(ctx context.Context, token string, userID int64) error {
+	key := refreshTokenKey(token)
+
+	owner, err := client.HGet(ctx, key, "user_id").Int64()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner != userID {
+		return nil
+	}
+	return client.Del(ctx, key).Err()
+}
+
+func parseRefreshTokenData(m map[string]string) (*RefreshTokenData, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	userID, err := strconv.ParseInt(m["user_id"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	version, err := strconv.ParseInt(m["version"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &RefreshTokenData{
+		UserID:       userID,
+		Username:     m["username"],
+		TokenVersion: version,
+	}, nil
+}
+
+// RevokeAccessToken 吊销 access token，记录保留到 token 本身过期为止
+func RevokeAccessToken(ctx context.Context, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	return client.Set(ctx, getRedisKey(KeyRevokedTokenPF+jti), 1, ttl).Err()
+}
+
+// GetUserTokenVersion 获取用户当前的 token 版本号，从未退出过所有设备时为 0
+func GetUserTokenVersion(ctx context.Context, userID int64) (int64, error) {
+	key := getRedisKey(KeyUserTokenVersionPF + strconv.FormatInt(userID, 10))
+	version, err := client.Get(ctx, key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// IncrUserTokenVersion 递增用户的 token 版本号，之前签发的所有 token 都将失效
+func IncrUserTokenVersion(ctx context.Context, userID int64) (int64, error) {
+	key := getRedisKey(KeyUserTokenVersionPF + strconv.FormatInt(userID, 10))
+	return client.Incr(ctx, key).Result()
+}
+
+// IsAccessTokenValid 检查 access token 是否已被吊销，以及签发时的版本号是否仍是用户的当前版本
+func IsAccessTokenValid(ctx context.Context, jti string, userID, tokenVersion int64) (bool, error) {
+	pipe := client.Pipeline()
+	revokedCmd := pipe.Exists(ctx, getRedisKey(KeyRevokedTokenPF+jti))
+	versionCmd := pipe.Get(ctx, getRedisKey(KeyUserTokenVersionPF+strconv.FormatInt(userID, 10)))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+
+	if revokedCmd.Val() > 0 {
+		return false, nil
+	}
+
+	var version int64
+	if versionCmd.Err() == nil {
+		v, err := versionCmd.Int64()
+		if err != nil {
+			return false, err
+		}
+		version = v
+	}
+	return version == tokenVersion, nil
+}
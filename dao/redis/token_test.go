@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshToken_KeyedByHash(t *testing.T) {
+	mr := setupMiniRedis(t)
+	ctx := context.Background()
+
+	token := "raw-refresh-token"
+	data := &RefreshTokenData{UserID: 1, Username: "alice", TokenVersion: 2}
+	require.NoError(t, SaveRefreshToken(ctx, token, data, time.Hour))
+
+	// Redis 中看不到 token 原文
+	for _, key := range mr.Keys() {
+		assert.False(t, strings.Contains(key, token), key)
+	}
+
+	got, err := TakeRefreshToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// 只能使用一次
+	got, err = TakeRefreshToken(ctx, token)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
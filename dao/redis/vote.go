@@ -2,72 +2,344 @@ package redis
 
 import (
 	"context"
+	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
+	"strconv"
 	"time"
 )
 
 const (
 	oneWeekInSeconds = 7 * 24 * 3600
-	scorePerVore     = 432
+	scorePerVote     = 432
 )
 
-func GetPostCreateTime(ctx context.Context, postID string) float64 {
-	return client.ZScore(ctx, getRedisKey(KeyPostTimeZset), postID).Val()
+// VoteResult 投票脚本的执行结果
+type VoteResult int64
+
+const (
+	VoteResultOK       VoteResult = iota // 投票成功
+	VoteResultExpired                    // 帖子不存在或已超过投票期
+	VoteResultRepeated                   // 与上一次投票相同，重复投票
+)
+
+/*
+voteScript 在 Redis 服务端原子地完成一次投票：
+ 1. 检查帖子是否存在，且仍在一周的投票期内
+ 2. 读取用户之前的投票，判断是否重复投票
+ 3. 按新旧票值之差更新帖子分数：(newVote - curVote) * scorePerVote
+ 4. 更新或移除用户的投票记录
+ 5. 更新用户的投票历史索引，投票期结束、post:voted:<postID> 被归档删除后仍可查询
+ 6. 将帖子标记为待同步，由后台任务把投票数据批量写入 MySQL
+
+把“读-判断-写”放进同一个脚本，同一用户的并发请求不会再读到相同的旧票值而重复计分
+
+KEYS[1]: post:time   KEYS[2]: post:score   KEYS[3]: post:voted:<postID>   KEYS[4]: vote:dirty
+KEYS[5]: user:voted:<userID>   KEYS[6]: user:vote_dir:<userID>
+ARGV[1]: postID   ARGV[2]: userID   ARGV[3]: 新的票值 1/0/-1
+ARGV[4]: 当前时间戳   ARGV[5]: 投票期（秒）   ARGV[6]: 每票的分值   ARGV[7]: 帖子在排行榜中的 member
+*/
+var voteScript = redis.NewScript(`
+local createTime = redis.call('ZSCORE', KEYS[1], ARGV[7])
+if not createTime then
+	return 1
+end
+if tonumber(ARGV[4]) - tonumber(createTime) >= tonumber(ARGV[5]) then
+	return 1
+end
+
+local newVote = tonumber(ARGV[3])
+local curVote = tonumber(redis.call('ZSCORE', KEYS[3], ARGV[2]) or '0')
+if newVote == curVote then
+	return 2
+end
+
+redis.call('ZINCRBY', KEYS[2], (newVote - curVote) * tonumber(ARGV[6]), ARGV[7])
+if newVote == 0 then
+	redis.call('ZREM', KEYS[3], ARGV[2])
+	redis.call('ZREM', KEYS[5], ARGV[1])
+	redis.call('HDEL', KEYS[6], ARGV[1])
+else
+	redis.call('ZADD', KEYS[3], newVote, ARGV[2])
+	redis.call('ZADD', KEYS[5], ARGV[4], ARGV[1])
+	redis.call('HSET', KEYS[6], ARGV[1], newVote)
+end
+redis.call('SADD', KEYS[4], ARGV[1])
+return 0
+`)
+
+// VoteForPost 执行投票脚本，返回投票结果
+//   - voteVal: 用户新的投票状态，1(赞成), -1(反对), 0(取消投票)
+//
+// 示例场景:
+//   - 没投过 -> 投赞成: 总分 +432
+//   - 投赞成 -> 投反对: 总分 -864
+//   - 投反对 -> 取消:   总分 +432，即把之前扣的补回来
+func VoteForPost(ctx context.Context, userID, postID string, voteVal int8) (VoteResult, error) {
+	keys := []string{
+		getRedisKey(KeyPostTimeZset),
+		getRedisKey(KeyPostScoreZset),
+		getRedisKey(KeyPostVotedZsetPF + postID),
+		getRedisKey(KeyVoteDirtySet),
+		getRedisKey(KeyUserVotedZsetPF + userID),
+		getRedisKey(KeyUserVoteDirHashPF + userID),
+	}
+
+	res, err := voteScript.Run(ctx, client, keys,
+		postID, userID, voteVal, time.Now().Unix(), oneWeekInSeconds, scorePerVote, postMember(postID)).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return VoteResult(res), nil
 }
 
-func IsPostCreatedWithinOneWeek(ctx context.Context, postID string) bool {
-	createTime := GetPostCreateTime(ctx, postID)
-	if createTime == 0 {
-		return false // 帖子不存在
+// GetVoteArchiveCursor 获取投票归档进度，未归档过时返回 0
+func GetVoteArchiveCursor(ctx context.Context) (float64, error) {
+	cursor, err := client.Get(ctx, getRedisKey(KeyVoteArchiveCursor)).Float64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
 	}
-	return time.Now().Unix()-int64(createTime) < oneWeekInSeconds
+	return cursor, err
 }
 
-func GetPostVoteScore(ctx context.Context, postID, userID string) float64 {
-	return client.ZScore(ctx, getRedisKey(KeyPostVotedZsetPF+postID), userID).Val()
+// SetVoteArchiveCursor 保存投票归档进度
+func SetVoteArchiveCursor(ctx context.Context, cursor float64) error {
+	return client.Set(ctx, getRedisKey(KeyVoteArchiveCursor), strconv.FormatFloat(cursor, 'f', -1, 64), 0).Err()
 }
 
-// UpdatePostVote 更新帖子分数与用户投票记录
-// 该函数在一个 Redis Pipeline 中原子性地完成以下操作：
-// 1. ZIncrBy: 根据 operate 和 diff 更新帖子的全局分数 (KeyPostScoreZset)
-// 2. ZAdd/ZRem: 更新或移除用户在帖子下的投票状态 (KeyPostVotedZsetPF)
-//
-// 参数说明:
-//   - ctx:      上下文
-//   - userID:   用户 ID
-//   - postID:   帖子 ID
-//   - voteVal:  用户当前的最终投票状态。
-//     取值: 1(赞成), -1(反对), 0(取消投票/无记录)。
-//     如果为 0，会从 Redis 记录中移除该用户；否则更新为对应值。
-//   - operate:  分数变化的方向系数。
-//     取值: 1 (表示分数增加), -1 (表示分数减少)。
-//     推导逻辑: if newVote > curVote then 1 else -1。
-//   - diff:     分数变化的幅度系数（绝对值）。
-//     取值: 1 (普通投票/取消), 2 (从赞成改反对，或反之)。
-//     推导逻辑: |newVote - curVote|。
-//
-// 示例场景:
-//   - 没投过 -> 投赞成: voteVal=1, operate=1, diff=1  (总分 +432)
-//   - 投赞成 -> 投反对: voteVal=-1, operate=-1, diff=2 (总分 -864)
-//   - 投反对 -> 取消:   voteVal=0, operate=1, diff=1  (总分 +432，即把之前扣的补回来)
-func UpdatePostVote(ctx context.Context, userID, postID string, voteVal, operate, diff float64) error {
-	// 开启 Redis 事务管道，确保后续操作要么全成功，要么全失败
+// GetVoteExpiredPosts 按发帖时间正序获取发帖时间不早于 since、且投票期已结束的帖子，Score 为发帖时间
+func GetVoteExpiredPosts(ctx context.Context, since float64, offset, count int64) ([]redis.Z, error) {
+	deadline := time.Now().Unix() - oneWeekInSeconds
+	zs, err := client.ZRangeByScoreWithScores(ctx, getRedisKey(KeyPostTimeZset), &redis.ZRangeBy{
+		Min:    strconv.FormatFloat(since, 'f', -1, 64),
+		Max:    strconv.FormatInt(deadline, 10),
+		Offset: offset,
+		Count:  count,
+	}).Result()
+	return decodePostMembers(zs), err
+}
+
+// GetPostVoteStats 统计帖子的赞成票数、反对票数以及当前热度分数
+// 帖子不在热度榜中（已被删除或 Redis 数据丢失）时 score 为 nil，调用方不应把它当作 0 写入 MySQL
+func GetPostVoteStats(ctx context.Context, postID string) (up, down int64, score *float64, err error) {
+	votedKey := getRedisKey(KeyPostVotedZsetPF + postID)
+
+	pipe := client.Pipeline()
+	upCmd := pipe.ZCount(ctx, votedKey, "1", "1")
+	downCmd := pipe.ZCount(ctx, votedKey, "-1", "-1")
+	scoreCmd := pipe.ZScore(ctx, getRedisKey(KeyPostScoreZset), postMember(postID))
+	if _, err = pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, nil, err
+	}
+
+	if scoreCmd.Err() == nil {
+		v := scoreCmd.Val()
+		score = &v
+	}
+	return upCmd.Val(), downCmd.Val(), score, nil
+}
+
+// PostVoteData 帖子的实时投票数据
+type PostVoteData struct {
+	UpVotes   int64
+	DownVotes int64
+	Direction int8 // 指定用户对该帖子的投票：1, 0, -1
+}
+
+/*
+GetPostsVoteData 批量获取帖子的赞成票数、反对票数以及 userID 对每个帖子的投票，结果与 postIDs 一一对应
+userID 为空时不查询用户的投票
+
+票数来自 post:voted:<postID>，投票期结束归档后为 0，调用方应改用 MySQL 中的票数；
+用户的投票来自 user:vote_dir:<userID>，归档后仍然保留，对所有帖子都有效
+*/
+func GetPostsVoteData(ctx context.Context, postIDs []string, userID string) ([]*PostVoteData, error) {
+	pipe := client.Pipeline()
+
+	upCmds := make([]*redis.IntCmd, len(postIDs))
+	downCmds := make([]*redis.IntCmd, len(postIDs))
+	for i, postID := range postIDs {
+		votedKey := getRedisKey(KeyPostVotedZsetPF + postID)
+		upCmds[i] = pipe.ZCount(ctx, votedKey, "1", "1")
+		downCmds[i] = pipe.ZCount(ctx, votedKey, "-1", "-1")
+	}
+	var dirCmd *redis.SliceCmd
+	if userID != "" && len(postIDs) > 0 {
+		dirCmd = pipe.HMGet(ctx, getRedisKey(KeyUserVoteDirHashPF+userID), postIDs...)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	res := make([]*PostVoteData, len(postIDs))
+	for i := range postIDs {
+		res[i] = &PostVoteData{
+			UpVotes:   upCmds[i].Val(),
+			DownVotes: downCmds[i].Val(),
+		}
+		if dirCmd == nil {
+			continue
+		}
+		// 用户没有投过票的帖子 HMGET 返回 nil
+		if dir, ok := dirCmd.Val()[i].(string); ok {
+			d, _ := strconv.ParseInt(dir, 10, 8)
+			res[i].Direction = int8(d)
+		}
+	}
+	return res, nil
+}
+
+// voteSyncLease 同步投票数据的租期，取出后超过租期仍未确认的帖子视为同步进程已崩溃，会被重新取出
+const voteSyncLease = 5 * time.Minute
+
+/*
+claimVoteDirtyScript 取出待同步的帖子，同时记入 vote:syncing
+
+先取超过租期仍未确认的帖子，不足 count 个再从 vote:dirty 中 SPOP 补足。
+帖子在同步成功、调用 AckVoteSyncedPost 之前一直留在 vote:syncing 中，进程崩溃不会丢失待同步的标记
+
+KEYS[1]: vote:dirty
+KEYS[2]: vote:syncing
+ARGV[1]: 最多取出的个数
+ARGV[2]: 当前时间
+ARGV[3]: 租期（秒）
+*/
+var claimVoteDirtyScript = redis.NewScript(`
+local count = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now - tonumber(ARGV[3]), 'LIMIT', 0, count)
+if #ids < count then
+	local popped = redis.call('SPOP', KEYS[1], count - #ids)
+	for _, id in ipairs(popped) do
+		table.insert(ids, id)
+	end
+end
+for _, id in ipairs(ids) do
+	redis.call('ZADD', KEYS[2], now, id)
+end
+return ids
+`)
+
+/*
+ClaimVoteDirtyPosts 取出最多 count 个等待同步投票数据的帖子
+
+取出的帖子记入 vote:syncing，同步成功后调用 AckVoteSyncedPost 删除，失败时调用 MarkVoteDirtyPosts 放回。
+同步期间的新投票会把帖子重新加入 vote:dirty，不受确认的影响，下一轮会再同步一次
+*/
+func ClaimVoteDirtyPosts(ctx context.Context, count int64) ([]string, error) {
+	keys := []string{getRedisKey(KeyVoteDirtySet), getRedisKey(KeyVoteSyncingZset)}
+	return claimVoteDirtyScript.Run(ctx, client, keys, count, time.Now().Unix(), int64(voteSyncLease/time.Second)).StringSlice()
+}
+
+// AckVoteSyncedPost 帖子的投票数据已写入 MySQL，从 vote:syncing 中删除
+func AckVoteSyncedPost(ctx context.Context, postID string) error {
+	return client.ZRem(ctx, getRedisKey(KeyVoteSyncingZset), postID).Err()
+}
+
+// MarkVoteDirtyPosts 重新标记帖子为待同步，用于同步失败后的重试
+func MarkVoteDirtyPosts(ctx context.Context, postIDs ...string) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+	members := make([]interface{}, 0, len(postIDs))
+	for _, id := range postIDs {
+		members = append(members, id)
+	}
 	pipe := client.TxPipeline()
+	pipe.SAdd(ctx, getRedisKey(KeyVoteDirtySet), members...)
+	pipe.ZRem(ctx, getRedisKey(KeyVoteSyncingZset), members...)
+	_, err := pipe.Exec(ctx)
+	return err
+}
 
-	// 更新帖子分数
-	// 计算公式：操作方向 * 差值 * 每票的分值
-	pipe.ZIncrBy(ctx, getRedisKey(KeyPostScoreZset), operate*diff*scorePerVore, postID)
+// DeletePostVoted 删除帖子的投票记录 zset
+func DeletePostVoted(ctx context.Context, postID string) error {
+	return client.Del(ctx, getRedisKey(KeyPostVotedZsetPF+postID)).Err()
+}
 
-	// 记录用户投票数据
-	if voteVal == 0 {
-		pipe.ZRem(ctx, getRedisKey(KeyPostVotedZsetPF+postID), userID)
-	} else {
-		pipe.ZAdd(ctx, getRedisKey(KeyPostVotedZsetPF+postID), redis.Z{
-			Score:  voteVal,
-			Member: userID,
-		})
+/*
+DeletePostVotes 清理被删除帖子的全部投票数据：
+  - 每个投票用户的投票历史 user:voted:<userID> 和 user:vote_dir:<userID> 中的该帖子
+  - 帖子的投票记录 post:voted:<postID>
+  - 待同步集合 vote:dirty 和 vote:syncing 中的该帖子，避免同步任务之后用空的投票记录覆盖 MySQL 中的票数
+
+调用前帖子应已移出时间榜（不再接受投票），且票数已归档到 MySQL
+*/
+func DeletePostVotes(ctx context.Context, postID string) error {
+	votedKey := getRedisKey(KeyPostVotedZsetPF + postID)
+	voters, err := client.ZRange(ctx, votedKey, 0, -1).Result()
+	if err != nil {
+		return err
 	}
 
+	pipe := client.TxPipeline()
+	for _, userID := range voters {
+		pipe.ZRem(ctx, getRedisKey(KeyUserVotedZsetPF+userID), postID)
+		pipe.HDel(ctx, getRedisKey(KeyUserVoteDirHashPF+userID), postID)
+	}
+	pipe.Del(ctx, votedKey)
+	pipe.SRem(ctx, getRedisKey(KeyVoteDirtySet), postID)
+	pipe.ZRem(ctx, getRedisKey(KeyVoteSyncingZset), postID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// UserVote 用户的一条投票记录
+type UserVote struct {
+	PostID    string
+	Direction int8
+	VoteTime  int64
+}
+
+// RemoveUserVotes 从用户的投票历史中移除帖子，用于清理已删除的帖子残留的记录
+func RemoveUserVotes(ctx context.Context, userID string, postIDs ...string) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+	members := make([]interface{}, 0, len(postIDs))
+	for _, id := range postIDs {
+		members = append(members, id)
+	}
+	pipe := client.TxPipeline()
+	pipe.ZRem(ctx, getRedisKey(KeyUserVotedZsetPF+userID), members...)
+	pipe.HDel(ctx, getRedisKey(KeyUserVoteDirHashPF+userID), postIDs...)
 	_, err := pipe.Exec(ctx)
 	return err
 }
+
+// GetUserVotes 按投票时间倒序分页获取用户的投票记录，同时返回投票总数
+func GetUserVotes(ctx context.Context, userID string, offset, count int64) (votes []*UserVote, total int64, err error) {
+	votedKey := getRedisKey(KeyUserVotedZsetPF + userID)
+
+	pipe := client.Pipeline()
+	totalCmd := pipe.ZCard(ctx, votedKey)
+	zsCmd := pipe.ZRevRangeWithScores(ctx, votedKey, offset, offset+count-1)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	zs := zsCmd.Val()
+	if len(zs) == 0 {
+		return nil, totalCmd.Val(), nil
+	}
+
+	postIDs := make([]string, 0, len(zs))
+	for _, z := range zs {
+		postIDs = append(postIDs, z.Member.(string))
+	}
+	dirs, err := client.HMGet(ctx, getRedisKey(KeyUserVoteDirHashPF+userID), postIDs...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	votes = make([]*UserVote, 0, len(zs))
+	for i, z := range zs {
+		v := &UserVote{PostID: postIDs[i], VoteTime: int64(z.Score)}
+		if dir, ok := dirs[i].(string); ok {
+			d, _ := strconv.ParseInt(dir, 10, 8)
+			v.Direction = int8(d)
+		}
+		votes = append(votes, v)
+	}
+	return votes, totalCmd.Val(), nil
+}
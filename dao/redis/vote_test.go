@@ -0,0 +1,276 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupMiniRedis 启动一个内存版 Redis，并将包内的 client 指向它
+func setupMiniRedis(t *testing.T) *miniredis.Miniredis {
+	mr := miniredis.RunT(t)
+	client = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return mr
+}
+
+// addPost 模拟发帖：写入时间榜和热度榜，member 为补零的帖子 ID
+func addPost(t *testing.T, postID string, createTime int64) {
+	ctx := context.Background()
+	z := redis.Z{Score: float64(createTime), Member: postMember(postID)}
+	require.NoError(t, client.ZAdd(ctx, getRedisKey(KeyPostTimeZset), z).Err())
+	require.NoError(t, client.ZAdd(ctx, getRedisKey(KeyPostScoreZset), z).Err())
+}
+
+func postScore(t *testing.T, postID string) float64 {
+	score, err := client.ZScore(context.Background(), getRedisKey(KeyPostScoreZset), postMember(postID)).Result()
+	require.NoError(t, err)
+	return score
+}
+
+func TestVoteForPost_Transitions(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	addPost(t, "1", now)
+
+	// 没投过 -> 赞成：+432
+	res, err := VoteForPost(ctx, "100", "1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, VoteResultOK, res)
+	assert.Equal(t, float64(now+scorePerVote), postScore(t, "1"))
+
+	// 再次赞成：重复投票，分数不变
+	res, err = VoteForPost(ctx, "100", "1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, VoteResultRepeated, res)
+	assert.Equal(t, float64(now+scorePerVote), postScore(t, "1"))
+
+	// 赞成 -> 反对：-864
+	res, err = VoteForPost(ctx, "100", "1", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, VoteResultOK, res)
+	assert.Equal(t, float64(now-scorePerVote), postScore(t, "1"))
+
+	// 反对 -> 取消：+432，且投票记录被移除
+	res, err = VoteForPost(ctx, "100", "1", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, VoteResultOK, res)
+	assert.Equal(t, float64(now), postScore(t, "1"))
+
+	exists, err := client.Exists(ctx, getRedisKey(KeyPostVotedZsetPF+"1")).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+}
+
+func TestVoteForPost_Expired(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	// 帖子不存在
+	res, err := VoteForPost(ctx, "100", "404", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, VoteResultExpired, res)
+
+	// 帖子已超过一周
+	createTime := time.Now().Unix() - oneWeekInSeconds - 1
+	addPost(t, "2", createTime)
+
+	res, err = VoteForPost(ctx, "100", "2", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, VoteResultExpired, res)
+	assert.Equal(t, float64(createTime), postScore(t, "2"))
+}
+
+func TestVoteForPost_Concurrent(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	addPost(t, "1", now)
+
+	// 同一用户并发发送多次相同的赞成票，只允许成功一次
+	const workers = 50
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		results  = make(map[VoteResult]int)
+		firstErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := VoteForPost(ctx, "100", "1", 1)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			results[res]++
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, firstErr)
+	assert.Equal(t, 1, results[VoteResultOK])
+	assert.Equal(t, workers-1, results[VoteResultRepeated])
+	assert.Equal(t, float64(now+scorePerVote), postScore(t, "1"))
+
+	// 不同用户并发投赞成票，每一票都要计入
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(uid int) {
+			defer wg.Done()
+			_, _ = VoteForPost(ctx, strconv.Itoa(1000+uid), "1", 1)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, float64(now+(workers+1)*scorePerVote), postScore(t, "1"))
+}
+
+func TestVoteForPost_UserVotes(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	addPost(t, "1", now)
+	addPost(t, "2", now)
+	addPost(t, "3", now)
+
+	_, err := VoteForPost(ctx, "100", "1", 1)
+	require.NoError(t, err)
+	_, err = VoteForPost(ctx, "100", "2", -1)
+	require.NoError(t, err)
+	_, err = VoteForPost(ctx, "100", "3", 1)
+	require.NoError(t, err)
+	// 取消投票后从投票记录中移除
+	_, err = VoteForPost(ctx, "100", "3", 0)
+	require.NoError(t, err)
+
+	votes, total, err := GetUserVotes(ctx, "100", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, votes, 2)
+
+	dirs := make(map[string]int8, len(votes))
+	for _, v := range votes {
+		dirs[v.PostID] = v.Direction
+	}
+	assert.Equal(t, map[string]int8{"1": 1, "2": -1}, dirs)
+
+	// 帖子的投票记录归档删除后，用户的投票记录仍然保留
+	require.NoError(t, DeletePostVoted(ctx, "1"))
+	votes, total, err = GetUserVotes(ctx, "100", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Len(t, votes, 2)
+}
+
+func TestDeletePostVotes(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	now := time.Now().Unix()
+	addPost(t, "1", now)
+	addPost(t, "2", now)
+	for _, userID := range []string{"100", "101"} {
+		_, err := VoteForPost(ctx, userID, "1", 1)
+		require.NoError(t, err)
+	}
+	_, err := VoteForPost(ctx, "100", "2", -1)
+	require.NoError(t, err)
+
+	// 删除帖子后不再接受投票，投票记录仍在，可以归档最终票数
+	require.NoError(t, DeletePost(ctx, 1, 0))
+	res, err := VoteForPost(ctx, "102", "1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, VoteResultExpired, res)
+	up, _, _, err := GetPostVoteStats(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), up)
+
+	require.NoError(t, DeletePostVotes(ctx, "1"))
+
+	exists, err := client.Exists(ctx, getRedisKey(KeyPostVotedZsetPF+"1")).Result()
+	require.NoError(t, err)
+	assert.Zero(t, exists)
+	dirty, err := client.SMembers(ctx, getRedisKey(KeyVoteDirtySet)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2"}, dirty)
+
+	// 投票用户的记录中只剩其他帖子
+	votes, total, err := GetUserVotes(ctx, "100", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, votes, 1)
+	assert.Equal(t, "2", votes[0].PostID)
+	_, total, err = GetUserVotes(ctx, "101", 0, 10)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+	dir, err := client.HExists(ctx, getRedisKey(KeyUserVoteDirHashPF+"101"), "1").Result()
+	require.NoError(t, err)
+	assert.False(t, dir)
+}
+
+func TestGetPostVoteStats_MissingScore(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	addPost(t, "1", time.Now().Unix())
+	_, _, score, err := GetPostVoteStats(ctx, "1")
+	require.NoError(t, err)
+	require.NotNil(t, score)
+
+	// 不在热度榜中的帖子没有分数，而不是 0 分
+	require.NoError(t, client.ZRem(ctx, getRedisKey(KeyPostScoreZset), postMember("1")).Err())
+	_, _, score, err = GetPostVoteStats(ctx, "1")
+	require.NoError(t, err)
+	assert.Nil(t, score)
+}
+
+func TestClaimVoteDirtyPosts(t *testing.T) {
+	setupMiniRedis(t)
+	ctx := context.Background()
+
+	require.NoError(t, MarkVoteDirtyPosts(ctx, "1", "2", "3"))
+
+	claimed, err := ClaimVoteDirtyPosts(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+
+	// 取出后还未确认的帖子留在 vote:syncing 中，租期内不会被再次取出
+	syncing, err := client.ZCard(ctx, getRedisKey(KeyVoteSyncingZset)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), syncing)
+	rest, err := ClaimVoteDirtyPosts(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, rest, 1)
+	assert.NotContains(t, claimed, rest[0])
+
+	// 确认一个，另一个同步失败放回 vote:dirty
+	require.NoError(t, AckVoteSyncedPost(ctx, claimed[0]))
+	require.NoError(t, MarkVoteDirtyPosts(ctx, claimed[1]))
+	dirty, err := client.SMembers(ctx, getRedisKey(KeyVoteDirtySet)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{claimed[1]}, dirty)
+	synced, err := client.ZRange(ctx, getRedisKey(KeyVoteSyncingZset), 0, -1).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{rest[0]}, synced)
+
+	// 超过租期仍未确认的帖子视为同步进程已崩溃，重新取出
+	expired := float64(time.Now().Add(-voteSyncLease - time.Minute).Unix())
+	require.NoError(t, client.ZAdd(ctx, getRedisKey(KeyVoteSyncingZset), redis.Z{Score: expired, Member: rest[0]}).Err())
+	again, err := ClaimVoteDirtyPosts(ctx, 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{rest[0], claimed[1]}, again)
+}
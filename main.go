@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/namelyzz/sayit/config"
 	"github.com/namelyzz/sayit/dao/mysql"
 	"github.com/namelyzz/sayit/dao/redis"
 	"github.com/namelyzz/sayit/middlewares"
 	"github.com/namelyzz/sayit/router"
+	"github.com/namelyzz/sayit/service"
+	"github.com/namelyzz/sayit/utils/jwt"
+	"github.com/namelyzz/sayit/utils/ranking"
 	"github.com/namelyzz/sayit/utils/snowflake"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	rebuildRedis := flag.Bool("rebuild-redis", false, "根据 MySQL 中的帖子重建 Redis 中的排行榜、社区集合和用户投票历史，完成后退出")
+	flag.Parse()
+
 	if err := config.Init("config/config.yaml"); err != nil {
 		fmt.Printf("load config failed, err:%v\n", err)
 		return
@@ -20,6 +34,8 @@ func main() {
 		fmt.Printf("init logger failed, err:%v\n", err)
 		return
 	}
+	// 最先注册、最后执行，保证退出前的日志都写入文件
+	defer func() { _ = zap.L().Sync() }()
 
 	if err := mysql.Init(config.Conf.MySQLConfig); err != nil {
 		fmt.Printf("init mysql failed, err:%v\n", err)
@@ -38,15 +54,102 @@ func main() {
 		return
 	}
 
+	if err := jwt.Init(config.Conf.JWTConfig); err != nil {
+		fmt.Printf("init jwt failed, err:%v\n", err)
+		return
+	}
+
+	ranking.Init(config.Conf.RankingConfig)
+
 	if err := middlewares.InitTrans("zh"); err != nil {
 		fmt.Printf("init validator trans failed, err:%v\n", err)
 		return
 	}
 
-	r := router.SetupRouter(config.Conf.Mode)
-	err := r.Run(fmt.Sprintf(":%d", config.Conf.Port))
+	if *rebuildRedis {
+		report, err := service.RebuildRedisFromMySQL(context.Background(), 0)
+		if err != nil {
+			fmt.Printf("rebuild redis failed, err:%v\n", err)
+			return
+		}
+		fmt.Printf("rebuild redis finished: %+v\n", *report)
+		return
+	}
+
+	if err := service.MigrateRedisPostMembers(context.Background()); err != nil {
+		fmt.Printf("migrate redis post members failed, err:%v\n", err)
+		return
+	}
+
+	var trustedProxies []string
+	if config.Conf.ServerConfig != nil {
+		trustedProxies = config.Conf.ServerConfig.TrustedProxies
+	}
+	r, err := router.SetupRouter(config.Conf.Mode, trustedProxies)
 	if err != nil {
-		fmt.Printf("run server failed, err:%v\n", err)
+		fmt.Printf("setup router failed, err:%v\n", err)
 		return
 	}
+
+	/*
+		后台任务分两组启动，退出时按顺序停止：
+		  1. 投票归档、投票同步、排行榜重建
+		  2. 发件箱转发，在 HTTP 请求和其他任务都结束之后才停止，停止前再处理一次它们写入的事件
+	*/
+	voteWorkers := startWorkers("vote",
+		func(ctx context.Context) { service.RunVoteArchiver(ctx, config.Conf.WorkerConfig) },
+		func(ctx context.Context) { service.RunVoteSyncer(ctx, config.Conf.WorkerConfig) },
+		func(ctx context.Context) {
+			if err := service.RebuildChangedRanks(ctx); err != nil {
+				zap.L().Error("service.RebuildChangedRanks failed", zap.Error(err))
+			}
+		},
+	)
+	outboxWorkers := startWorkers("outbox",
+		func(ctx context.Context) { service.RunOutboxRelay(ctx, config.Conf.WorkerConfig) },
+	)
+
+	srv := newHTTPServer(config.Conf.Port, r, config.Conf.ServerConfig)
+	metricsSrv := newMetricsServer(config.Conf.ServerConfig)
+
+	serveErr := make(chan error, 2)
+	for _, s := range []*http.Server{srv, metricsSrv} {
+		go func() {
+			zap.L().Info("server started", zap.String("addr", s.Addr))
+			if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+	}
+
+	// 等待退出信号：SIGINT 来自 Ctrl+C，SIGTERM 来自 kill 和容器编排的滚动发布
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-quit:
+		zap.L().Info("shutting down server", zap.String("signal", sig.String()))
+	case err := <-serveErr:
+		zap.L().Error("run server failed", zap.Error(err))
+	}
+
+	/*
+		关闭顺序：
+		  1. 停止接收新连接，等待正在处理的请求完成
+		  2. 按顺序停止后台任务
+		  3. 停止指标服务，退出过程中的指标仍然可以采集
+		  4. 函数返回时依次执行 defer：关闭 Redis、MySQL，最后刷新日志
+		所有步骤共用一个截止时间，超时后不再等待，直接退出
+	*/
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout(config.Conf.ServerConfig))
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		zap.L().Error("server shutdown failed", zap.Error(err))
+	}
+	voteWorkers.stop(ctx)
+	outboxWorkers.stop(ctx)
+	if err := metricsSrv.Shutdown(ctx); err != nil {
+		zap.L().Error("metrics server shutdown failed", zap.Error(err))
+	}
+	zap.L().Info("server exited")
 }
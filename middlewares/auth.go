@@ -2,8 +2,11 @@ package middlewares
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/namelyzz/sayit/service"
 	"github.com/namelyzz/sayit/utils/api"
 	"github.com/namelyzz/sayit/utils/jwt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
 	"strings"
 )
 
@@ -16,23 +19,57 @@ func JWTAuthMiddleware() func(c *gin.Context) {
 			return
 		}
 
-		parts := strings.SplitN(authHeader, " ", 2)
-		if !(len(parts) == 2 && parts[0] == "Bearer") {
-			api.ResponseError(c, api.CodeInvalidToken)
-			c.Abort()
+		if !authenticate(c, authHeader) {
 			return
 		}
+		c.Next() // 后续的处理请求的函数中 可以用过c.Get(CtxUserIDKey) 来获取当前请求的用户信息
+	}
+}
 
-		mc, err := jwt.ParseJWTToken(parts[1])
-		if err != nil {
-			api.ResponseError(c, api.CodeInvalidToken)
-			c.Abort()
+// OptionalJWTAuthMiddleware 用于游客也能访问的接口：没有携带 token 时按游客处理，
+// 携带了 token 则与 JWTAuthMiddleware 一样校验，通过后处理函数可以拿到当前用户
+func OptionalJWTAuthMiddleware() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		authHeader := c.Request.Header.Get("Authorization")
+		if authHeader != "" && !authenticate(c, authHeader) {
 			return
 		}
+		c.Next()
+	}
+}
 
-		// 将当前请求的userID信息保存到请求的上下文c上
-		c.Set(api.CtxUserIDKey, mc.UserID)
+// authenticate 校验 Authorization 头中的 token，通过后将用户信息保存到请求的上下文；
+// 校验失败时已写入错误响应并中止请求，返回 false
+func authenticate(c *gin.Context, authHeader string) bool {
+	parts := strings.SplitN(authHeader, " ", 2)
+	if !(len(parts) == 2 && parts[0] == "Bearer") {
+		api.ResponseError(c, api.CodeInvalidToken)
+		c.Abort()
+		return false
+	}
 
-		c.Next() // 后续的处理请求的函数中 可以用过c.Get(CtxUserIDKey) 来获取当前请求的用户信息
+	mc, err := jwt.ParseJWTToken(parts[1])
+	if err != nil {
+		api.ResponseError(c, api.CodeInvalidToken)
+		c.Abort()
+		return false
 	}
+
+	// 签名校验通过后，再检查 token 是否已被吊销（退出登录、退出所有设备）
+	if err = service.CheckAccessToken(c.Request.Context(), mc); err != nil {
+		if errors.Is(err, api.ErrorInvalidToken) {
+			api.ResponseError(c, api.CodeInvalidToken)
+		} else {
+			zap.L().Error("service.CheckAccessToken failed", zap.Error(err))
+			api.ResponseError(c, api.CodeServerBusy)
+		}
+		c.Abort()
+		return false
+	}
+
+	// 将当前请求的userID信息保存到请求的上下文c上
+	c.Set(api.CtxUserIDKey, mc.UserID)
+	c.Set(api.CtxTokenIDKey, mc.ID)
+	c.Set(api.CtxTokenExpireKey, mc.ExpiresAt.Time)
+	return true
 }
@@ -0,0 +1,195 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/namelyzz/sayit/dao/redis"
+	"github.com/namelyzz/sayit/utils/api"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	idempotencyTTL            = 24 * time.Hour
+	maxIdempotencyKeyLength   = 255
+	idempotencyReservationTTL = 1 * time.Minute // 首次请求处理期间的占用时长，处理期间定期续期，进程崩溃时键会在此之后自动释放
+)
+
+// bodyWriter 在写出响应的同时保存一份响应体
+type bodyWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+/*
+IdempotencyMiddleware 支持 Idempotency-Key 请求头，需要放在 JWTAuthMiddleware 之后
+
+同一用户用同一个键发出的请求只会被处理一次：
+  - 首次请求：占用键并处理请求，处理完成后保存响应，保留 24 小时
+  - 重试请求：请求内容（方法、路径、请求体）相同时回放首次的响应，并带上 Idempotent-Replayed: true；
+    内容不同时拒绝；首次请求仍在处理中时返回 CodeRequestInProgress
+  - 首次请求返回服务繁忙等系统错误时不保存响应，释放键，客户端可以用同一个键重试
+
+没有带该请求头的请求不受影响。Redis 不可用时放行请求，只是不再保证幂等
+*/
+func IdempotencyMiddleware() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			api.ResponseErrorWithMsg(c, api.CodeInvalidParam, "Idempotency-Key 过长")
+			c.Abort()
+			return
+		}
+
+		userID, err := api.GetCurrentUserID(c)
+		if err != nil {
+			api.ResponseError(c, api.CodeNeedLogin)
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			api.ResponseError(c, api.CodeInvalidParam)
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.Path, body)
+
+		record, err := redis.ReserveIdempotencyKey(ctx, userID, key, fingerprint, idempotencyReservationTTL)
+		if err != nil {
+			zap.L().Warn("redis.ReserveIdempotencyKey failed", zap.Error(err))
+			c.Next()
+			return
+		}
+		if record != nil {
+			replayIdempotentResponse(c, record, fingerprint)
+			return
+		}
+
+		// 客户端断开连接后请求仍可能继续处理，续期和保存结果都不受影响
+		ctx = context.WithoutCancel(ctx)
+		stopRefresh := keepIdempotencyReservation(ctx, userID, key)
+		// 处理请求时 panic 也要停止续期，键在占用时长之后自动释放
+		defer stopRefresh()
+
+		w := &bodyWriter{ResponseWriter: c.Writer, body: new(bytes.Buffer)}
+		c.Writer = w
+		c.Next()
+
+		stopRefresh()
+		if !isSuccessfulResponse(w.Status(), w.body.Bytes()) {
+			if err = redis.ReleaseIdempotencyKey(ctx, userID, key); err != nil {
+				zap.L().Warn("redis.ReleaseIdempotencyKey failed", zap.Error(err))
+			}
+			return
+		}
+
+		record = &redis.IdempotencyRecord{
+			Fingerprint: fingerprint,
+			Status:      w.Status(),
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        w.body.Bytes(),
+		}
+		if err = redis.SaveIdempotencyResponse(ctx, userID, key, record, idempotencyTTL); err != nil {
+			zap.L().Warn("redis.SaveIdempotencyResponse failed", zap.Error(err))
+		}
+	}
+}
+
+// keepIdempotencyReservation 在首次请求处理期间定期续期占用，返回的函数停止续期并等待续期的 goroutine 退出，
+// 之后才能保存响应，否则续期可能把已保存响应的有效期改回占用时长。返回的函数可以多次调用
+func keepIdempotencyReservation(ctx context.Context, userID int64, key string) (stop func()) {
+	done := make(chan struct{})
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(idempotencyReservationTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := redis.RefreshIdempotencyReservation(ctx, userID, key, idempotencyReservationTTL); err != nil {
+					zap.L().Warn("redis.RefreshIdempotencyReservation failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}
+}
+
+func replayIdempotentResponse(c *gin.Context, record *redis.IdempotencyRecord, fingerprint string) {
+	defer c.Abort()
+
+	if record.Fingerprint != fingerprint {
+		api.ResponseError(c, api.CodeIdempotencyKeyReused)
+		return
+	}
+	if !record.Done {
+		api.ResponseError(c, api.CodeRequestInProgress)
+		return
+	}
+
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Data(record.Status, record.ContentType, record.Body)
+}
+
+// requestFingerprint 计算请求的指纹，同一个键用于不同的接口或不同的请求体时指纹不同
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isSuccessfulResponse 判断响应是否需要保存：系统错误说明请求可能没有处理完，不保存，允许重试；
+// 参数错误、重复投票等业务错误重试也不会有不同的结果，照常保存
+func isSuccessfulResponse(status int, body []byte) bool {
+	if status >= http.StatusInternalServerError {
+		return false
+	}
+
+	var resp struct {
+		Code api.ResCode `json:"code"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false
+	}
+	return resp.Code != api.CodeServerBusy
+}
@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/namelyzz/sayit/service"
+	"github.com/namelyzz/sayit/utils/api"
+	"go.uber.org/zap"
+	"strconv"
+)
+
+// CommunityModeratorMiddleware 校验当前用户是否为路径参数 :id 对应社区的版主，需要放在 JWTAuthMiddleware 之后
+func CommunityModeratorMiddleware() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		userID, err := api.GetCurrentUserID(c)
+		if err != nil {
+			api.ResponseError(c, api.CodeNeedLogin)
+			c.Abort()
+			return
+		}
+
+		communityID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			api.ResponseError(c, api.CodeInvalidParam)
+			c.Abort()
+			return
+		}
+
+		ok, err := service.IsCommunityModerator(communityID, userID)
+		if err != nil {
+			zap.L().Error("service.IsCommunityModerator failed",
+				zap.Int64("communityID", communityID),
+				zap.Int64("userID", userID),
+				zap.Error(err))
+			api.ResponseError(c, api.CodeServerBusy)
+			c.Abort()
+			return
+		}
+		if !ok {
+			api.ResponseError(c, api.CodeNoPermission)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Comment 帖子评论
+// ParentID 为 0 表示直接回复帖子的一级评论；
+// RootID 记录所属的一级评论 ID，一级评论自身的 RootID 为 0，便于一次取出整棵回复树
+type Comment struct {
+	CommentID  int64     `json:"comment_id" gorm:"column:comment_id"`
+	PostID     int64     `json:"post_id" gorm:"column:post_id"`
+	ParentID   int64     `json:"parent_id" gorm:"column:parent_id"`
+	RootID     int64     `json:"root_id" gorm:"column:root_id"`
+	AuthorID   int64     `json:"author_id" gorm:"column:author_id"`
+	Content    string    `json:"content" gorm:"column:content"`
+	Status     int32     `json:"status" gorm:"column:status;default:1"`
+	CreateTime time.Time `json:"create_time" gorm:"column:create_time;autoCreateTime"`
+	UpdateTime time.Time `json:"update_time" gorm:"column:update_time;autoUpdateTime"`
+}
+
+func (Comment) TableName() string {
+	return "comment"
+}
+
+// CommentItem 评论列表项，附带作者名称
+type CommentItem struct {
+	Comment
+	AuthorName string `json:"author_name" gorm:"column:author_name"`
+}
+
+// CommentNode 评论树节点
+// 一级评论只附带最早的若干条回复，ReplyCount 为全部回复数，超出的部分通过回复列表接口分页获取
+type CommentNode struct {
+	*CommentItem
+	ReplyCount int64          `json:"reply_count"`
+	Replies    []*CommentNode `json:"replies"`
+}
+
+// CommentList 分页后的评论树，分页以一级评论为单位
+type CommentList struct {
+	Total    int64          `json:"total"`
+	Comments []*CommentNode `json:"comments"`
+}
+
+// CommentReplyList 分页后的一级评论回复，按发表时间正序排列，由客户端按 parent_id 组装
+type CommentReplyList struct {
+	Total   int64          `json:"total"`
+	Replies []*CommentItem `json:"replies"`
+}
@@ -3,8 +3,9 @@ package models
 import "time"
 
 type Community struct {
-	ID   int64  `json:"community_id" gorm:"column:community_id"`
-	Name string `json:"name" gorm:"column:community_name"`
+	ID              int64  `json:"community_id" gorm:"column:community_id"`
+	Name            string `json:"name" gorm:"column:community_name"`
+	SubscriberCount int64  `json:"subscriber_count" gorm:"column:subscriber_count"`
 }
 
 func (Community) TableName() string {
@@ -12,12 +13,36 @@ func (Community) TableName() string {
 }
 
 type CommunityDetail struct {
-	ID           int64     `json:"community_id" gorm:"column:community_id"`
-	Name         string    `json:"name" gorm:"column:community_name"`
-	Introduction string    `json:"introduction,omitempty" gorm:"introduction"`
-	CreateTime   time.Time `json:"create_time" gorm:"create_time"`
+	ID              int64     `json:"community_id" gorm:"column:community_id"`
+	Name            string    `json:"name" gorm:"column:community_name"`
+	Introduction    string    `json:"introduction,omitempty" gorm:"introduction"`
+	CreatorID       int64     `json:"creator_id,string" gorm:"column:creator_id"`
+	SubscriberCount int64     `json:"subscriber_count" gorm:"column:subscriber_count;default:0"`
+	CreateTime      time.Time `json:"create_time" gorm:"column:create_time;autoCreateTime"`
 }
 
 func (CommunityDetail) TableName() string {
 	return "community"
 }
+
+// CommunityModerator 社区版主，版主可以在所管理的社区内置顶、锁定和移除帖子
+type CommunityModerator struct {
+	CommunityID int64     `json:"community_id" gorm:"column:community_id"`
+	UserID      int64     `json:"user_id,string" gorm:"column:user_id"`
+	CreateTime  time.Time `json:"create_time" gorm:"column:create_time;autoCreateTime"`
+}
+
+func (CommunityModerator) TableName() string {
+	return "community_moderator"
+}
+
+// CommunitySubscription 用户订阅的社区，首页 feed 由订阅社区的帖子合并而成
+type CommunitySubscription struct {
+	CommunityID int64     `json:"community_id" gorm:"column:community_id"`
+	UserID      int64     `json:"user_id,string" gorm:"column:user_id"`
+	CreateTime  time.Time `json:"create_time" gorm:"column:create_time;autoCreateTime"`
+}
+
+func (CommunitySubscription) TableName() string {
+	return "community_subscription"
+}
@@ -0,0 +1,19 @@
+package models
+
+// 健康检查的状态
+const (
+	HealthStatusUp   = "up"
+	HealthStatusDown = "down"
+)
+
+// DependencyHealth 单个依赖（MySQL、Redis）的检查结果，/readyz 无需登录，失败原因只记录在日志中，不返回给调用方
+type DependencyHealth struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"` // 检查耗时（毫秒）
+}
+
+// Readiness 就绪检查的结果，所有依赖都可用时 Status 为 up
+type Readiness struct {
+	Status       string                       `json:"status"`
+	Dependencies map[string]*DependencyHealth `json:"dependencies"`
+}
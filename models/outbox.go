@@ -0,0 +1,66 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 发件箱事件状态，处理成功的事件直接删除
+const (
+	OutboxStatusPending int32 = 0 // 等待处理或等待重试
+	OutboxStatusFailed  int32 = 1 // 超过最大重试次数，需要人工处理
+)
+
+// 发件箱事件类型
+const (
+	OutboxEventPostCreated = "post.created"
+	OutboxEventPostUpdated = "post.updated"
+	OutboxEventPostDeleted = "post.deleted"
+)
+
+/*
+OutboxEvent 发件箱事件
+
+与业务数据在同一个 MySQL 事务中写入，由后台任务读取并在 Redis 中执行对应的操作，
+业务数据提交成功，Redis 的修改就一定会被执行（至少一次），事件的处理必须是幂等的
+*/
+type OutboxEvent struct {
+	ID            int64     `json:"id" gorm:"column:id;primaryKey"`
+	EventType     string    `json:"event_type" gorm:"column:event_type"`
+	AggregateID   int64     `json:"aggregate_id" gorm:"column:aggregate_id"`
+	Payload       string    `json:"payload" gorm:"column:payload"`
+	Status        int32     `json:"status" gorm:"column:status;default:0"`
+	Attempts      int       `json:"attempts" gorm:"column:attempts;default:0"`
+	NextRetryTime time.Time `json:"next_retry_time" gorm:"column:next_retry_time"`
+	LastError     string    `json:"last_error" gorm:"column:last_error"`
+	CreateTime    time.Time `json:"create_time" gorm:"column:create_time;autoCreateTime"`
+
+	ClaimToken      string     `json:"-" gorm:"column:claim_token"`       // 认领该事件的标识，只有持有者可以删除事件或记录失败
+	ClaimExpireTime *time.Time `json:"-" gorm:"column:claim_expire_time"` // 认领的过期时间，持有者崩溃后事件可以被重新认领
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox"
+}
+
+// NewOutboxEvent 创建一个立即可以处理的事件，payload 序列化为 JSON 保存
+func NewOutboxEvent(eventType string, aggregateID int64, payload interface{}) (*OutboxEvent, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxEvent{
+		EventType:     eventType,
+		AggregateID:   aggregateID,
+		Payload:       string(b),
+		NextRetryTime: time.Now(),
+	}, nil
+}
+
+// PostEventPayload 帖子相关事件的内容
+type PostEventPayload struct {
+	PostID      int64   `json:"post_id,string"`
+	CommunityID int64   `json:"community_id"`
+	CreateTime  int64   `json:"create_time,omitempty"` // 发帖时间的 Unix 秒，只有 post.created 事件有
+	Score       float64 `json:"score,omitempty"`       // 初始热度分数，只有 post.created 事件有
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"fmt"
 	"strings"
+	"unicode/utf8"
 )
 
 // ParamSignUp 注册请求参数
@@ -18,6 +19,40 @@ type ParamLogin struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// ParamUpdateProfile 修改个人资料请求参数，只修改传入的字段，传空字符串表示清空
+type ParamUpdateProfile struct {
+	Bio    *string `json:"bio" binding:"omitempty,max=256"`
+	Avatar *string `json:"avatar" binding:"omitempty,http_url,max=256"` // 只允许 http/https，拒绝 javascript:、data: 等链接
+	Email  *string `json:"email" binding:"omitempty,email,max=64"`
+	Gender *int8   `json:"gender" binding:"omitempty,oneof=0 1 2"`
+}
+
+// ParamRefreshToken 刷新 token 请求参数
+type ParamRefreshToken struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// ParamLogout 退出登录请求参数，refresh_token 可选，传入时一并作废
+type ParamLogout struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ParamCreateCommunity 创建社区请求参数
+type ParamCreateCommunity struct {
+	Name         string `json:"name" binding:"required,max=128"`
+	Introduction string `json:"introduction" binding:"required,max=256"`
+}
+
+// ParamUpdateCommunity 修改社区简介请求参数
+type ParamUpdateCommunity struct {
+	Introduction string `json:"introduction" binding:"required,max=256"`
+}
+
+// ParamAddModerator 添加版主请求参数
+type ParamAddModerator struct {
+	UserID int64 `json:"user_id,string" binding:"required"`
+}
+
 /*
 定义排序字段和方向的枚举类型
 */
@@ -28,8 +63,26 @@ const (
 	SortFieldCreateTime SortField = "create_time"
 	SortFieldUpdateTime SortField = "update_time"
 	SortFieldScore      SortField = "score"
+
+	// 以下排序字段由 utils/ranking 中的排序策略计算，各自对应 Redis 中的一个排行榜
+	SortFieldHot           SortField = "hot"
+	SortFieldBest          SortField = "best"
+	SortFieldControversial SortField = "controversial"
 )
 
+// RankSortFields 由排序策略计算分数的排序字段
+var RankSortFields = []SortField{SortFieldHot, SortFieldBest, SortFieldControversial}
+
+// IsRank 是否为由排序策略计算分数的排序字段
+func (f SortField) IsRank() bool {
+	for _, rf := range RankSortFields {
+		if f == rf {
+			return true
+		}
+	}
+	return false
+}
+
 type SortDirection string
 
 const (
@@ -48,6 +101,12 @@ type ParamPostList struct {
 	CommunityID int64  `json:"community_id" form:"community_id"`
 	UserName    string `json:"user_name" form:"user_name"`
 	Keyword     string `json:"keyword" form:"keyword"`
+	// AuthorID 按作者 id 精确筛选，来自路径参数 /users/:id/posts
+	AuthorID int64 `json:"-" form:"-"`
+	// CommunityIDs 首页 feed 中用户订阅的社区，合并这些社区的帖子
+	CommunityIDs []int64 `json:"-" form:"-"`
+	// PinnedIDs 社区中置顶的帖子，由 service 放在第一页的最前面，列表本身不再包含它们
+	PinnedIDs []int64 `json:"-" form:"-"`
 
 	// 按 创建时间 的范围查询
 	StartTime *int64 `json:"start_time" form:"start_time"`
@@ -58,6 +117,11 @@ type ParamPostList struct {
 	Status *int          `json:"status" form:"status"`
 	SortBy SortField     `json:"sort_by" form:"sort_by"`
 	Order  SortDirection `json:"order" form:"order"`
+
+	// 游标分页：传入上一页响应中的 next_cursor（或响应头 X-Next-Cursor），此时忽略 page 参数
+	Cursor string `json:"cursor" form:"cursor"`
+	// After 由 Cursor 解析而来
+	After *PostCursor `json:"-" form:"-"`
 }
 
 const (
@@ -104,8 +168,8 @@ func (p *ParamPostList) ValidateAndSetDefaults() error {
 		SortFieldUpdateTime: true,
 		SortFieldScore:      true,
 	}
-	if !validSortFields[p.SortBy] {
-		return fmt.Errorf("invalid sort_by: %s, supported: create_time, update_time, score", p.SortBy)
+	if !validSortFields[p.SortBy] && !p.SortBy.IsRank() {
+		return fmt.Errorf("invalid sort_by: %s, supported: create_time, update_time, score, hot, best, controversial", p.SortBy)
 	}
 
 	// 验证排序方向
@@ -117,6 +181,108 @@ func (p *ParamPostList) ValidateAndSetDefaults() error {
 		return fmt.Errorf("invalid order: %s, supported: desc, asc", p.Order)
 	}
 
+	// 解析游标，游标必须和本次请求的排序方式一致
+	if p.Cursor != "" {
+		c, err := DecodePostCursor(p.Cursor)
+		if err != nil {
+			return fmt.Errorf("invalid cursor: %s", p.Cursor)
+		}
+		if c.SortBy != p.SortBy || c.Order != p.Order {
+			return fmt.Errorf("cursor does not match sort_by and order")
+		}
+		p.After = c
+		p.Page = 1
+	}
+
+	return nil
+}
+
+// ParamUpdatePost 编辑帖子请求参数
+type ParamUpdatePost struct {
+	Title   string `json:"title" binding:"required,max=128"`
+	Content string `json:"content" binding:"required,max=8192"`
+}
+
+// ParamComment 发表评论请求参数
+type ParamComment struct {
+	ParentID int64  `json:"parent_id"`                           // 回复的评论 id，为 0 表示直接评论帖子
+	Content  string `json:"content" binding:"required,max=1024"` // 评论内容
+}
+
+// ParamCommentList 获取评论列表请求参数，按一级评论分页
+type ParamCommentList struct {
+	Page int `json:"page" form:"page"`
+	Size int `json:"size" form:"size"`
+}
+
+func (p *ParamCommentList) ValidateAndSetDefaults() {
+	if p.Page <= 0 {
+		p.Page = 1
+	}
+	if p.Size <= 0 || p.Size > MaxPageSize {
+		p.Size = MaxPageSize
+	}
+}
+
+// ParamUserVoteList 获取当前用户投票记录请求参数
+type ParamUserVoteList struct {
+	Page int `json:"page" form:"page"`
+	Size int `json:"size" form:"size"`
+}
+
+func (p *ParamUserVoteList) ValidateAndSetDefaults() {
+	if p.Page <= 0 {
+		p.Page = 1
+	}
+	if p.Size <= 0 || p.Size > MaxPageSize {
+		p.Size = MaxPageSize
+	}
+}
+
+// ParamSearch 全文搜索请求参数
+type ParamSearch struct {
+	Q           string `json:"q" form:"q" binding:"required"` // 搜索词，多个词之间用空格分隔
+	CommunityID int64  `json:"community_id" form:"community_id"`
+	AuthorID    int64  `json:"author_id" form:"author_id"`
+
+	// 按 创建时间 的范围查询
+	StartTime *int64 `json:"start_time" form:"start_time"`
+	EndTime   *int64 `json:"end_time" form:"end_time"`
+
+	Page int `json:"page" form:"page"`
+	Size int `json:"size" form:"size"`
+}
+
+const (
+	// MinSearchLength 搜索词的最短字符数，与 MySQL ngram 分词的 ngram_token_size 默认值一致，更短的词搜不到结果
+	MinSearchLength = 2
+	// MaxSearchLength 搜索词的最长字符数
+	MaxSearchLength = 64
+	// MaxSearchResults 最多能翻到的搜索结果数，相关度排序只能用 OFFSET 分页，限制深度避免慢查询
+	MaxSearchResults = 1000
+)
+
+func (p *ParamSearch) ValidateAndSetDefaults() error {
+	p.Q = strings.TrimSpace(p.Q)
+	if n := utf8.RuneCountInString(p.Q); n < MinSearchLength || n > MaxSearchLength {
+		return fmt.Errorf("q must be %d to %d characters", MinSearchLength, MaxSearchLength)
+	}
+
+	if p.StartTime != nil && p.EndTime != nil {
+		if *p.StartTime > *p.EndTime {
+			return fmt.Errorf("start_time cannot be greater than end_time")
+		}
+	}
+
+	if p.Page <= 0 {
+		p.Page = 1
+	}
+	if p.Size <= 0 || p.Size > MaxPageSize {
+		p.Size = MaxPageSize
+	}
+	if p.Page*p.Size > MaxSearchResults {
+		return fmt.Errorf("page too deep, at most %d results", MaxSearchResults)
+	}
 	return nil
 }
 
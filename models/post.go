@@ -1,16 +1,33 @@
 package models
 
-import "time"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// 帖子状态
+const (
+	PostStatusDeleted int32 = 0 // 已删除（软删除）
+	PostStatusNormal  int32 = 1 // 正常
+)
 
 type Post struct {
-	PostID      int64     `json:"post_id" gorm:"column:post_id"`
-	Title       string    `json:"title" gorm:"column:title" binding:"required"`
-	Content     string    `json:"content" gorm:"column:content" binding:"required"`
-	AuthorID    int64     `json:"author_id" gorm:"column:author_id"`
-	CommunityID int64     `json:"community_id" gorm:"column:community_id" binding:"required"`
-	Status      int32     `json:"status" gorm:"column:status;default:1"`
-	CreateTime  time.Time `json:"create_time" gorm:"column:create_time;autoCreateTime"`
-	UpdateTime  time.Time `json:"update_time" gorm:"column:update_time;autoUpdateTime"`
+	PostID       int64     `json:"post_id" gorm:"column:post_id"`
+	Title        string    `json:"title" gorm:"column:title" binding:"required"`
+	Content      string    `json:"content" gorm:"column:content" binding:"required"`
+	AuthorID     int64     `json:"author_id" gorm:"column:author_id"`
+	CommunityID  int64     `json:"community_id" gorm:"column:community_id" binding:"required"`
+	Status       int32     `json:"status" gorm:"column:status;default:1"`
+	CommentCount int64     `json:"comment_count" gorm:"column:comment_count;default:0"`
+	UpVotes      int64     `json:"up_votes" gorm:"column:up_votes;default:0"`
+	DownVotes    int64     `json:"down_votes" gorm:"column:down_votes;default:0"`
+	Score        float64   `json:"score" gorm:"column:score;default:0"`
+	Pinned       bool      `json:"pinned" gorm:"column:pinned;default:0"`
+	Locked       bool      `json:"locked" gorm:"column:locked;default:0"`
+	VoteArchived bool      `json:"-" gorm:"column:vote_archived;default:0"`
+	CreateTime   time.Time `json:"create_time" gorm:"column:create_time;autoCreateTime"`
+	UpdateTime   time.Time `json:"update_time" gorm:"column:update_time;autoUpdateTime"`
 }
 
 func (Post) TableName() string {
@@ -35,10 +52,69 @@ type PostListItem struct {
 	Status        int32     `json:"status"`
 	CreateTime    time.Time `json:"create_time"`
 	UpdateTime    time.Time `json:"update_time"`
-	CommentCount  int64     `json:"comment_count"` // 评论数（可后续添加）
-	LikeCount     int64     `json:"like_count"`    // 点赞数（可后续添加）
+	CommentCount  int64     `json:"comment_count"`  // 评论数
+	LikeCount     int64     `json:"like_count"`     // 赞成票数
+	DislikeCount  int64     `json:"dislike_count"`  // 反对票数
+	VoteDirection int8      `json:"vote_direction"` // 当前用户的投票：1(赞成), 0(未投票), -1(反对)
+	Score         float64   `json:"score"`          // 热度分数
+	Pinned        bool      `json:"pinned"`         // 是否被版主置顶
+	Locked        bool      `json:"locked"`         // 是否被版主锁定
+	VoteArchived  bool      `json:"-"`              // 投票数据是否已归档到 MySQL
 }
 
 func (PostListItem) TableName() string {
 	return "post"
 }
+
+// PostList 帖子列表接口的响应
+type PostList struct {
+	List []*PostListItem `json:"list"`
+	// NextCursor 下一页的游标，没有更多数据时为空
+	NextCursor string `json:"next_cursor"`
+}
+
+// UserVoteItem 用户投票记录中的一项，VoteDirection 即该用户的投票
+type UserVoteItem struct {
+	*PostListItem
+	VoteTime int64 `json:"vote_time"` // 投票时间戳
+}
+
+// UserVoteList 用户投票记录接口的响应
+type UserVoteList struct {
+	Total int64           `json:"total"`
+	List  []*UserVoteItem `json:"list"`
+}
+
+/*
+PostCursor 帖子列表的分页游标，记录上一页最后一个帖子的排序值和帖子 ID
+
+  - 走 Redis 时，Value 为 zset 中的 score，PostID 为 member
+  - 走 MySQL 时，Value 为排序字段的值（时间取 Unix 秒），PostID 用于排序值相同时的定位
+
+SortBy 和 Order 用于校验游标与本次请求的排序方式是否一致。对客户端来说游标是不透明的字符串
+*/
+type PostCursor struct {
+	SortBy SortField     `json:"s"`
+	Order  SortDirection `json:"o"`
+	Value  float64       `json:"v"`
+	PostID int64         `json:"id"`
+}
+
+// EncodePostCursor 将游标编码为 URL 安全的字符串
+func EncodePostCursor(c *PostCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodePostCursor 解析客户端传入的游标
+func DecodePostCursor(s string) (*PostCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := new(PostCursor)
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
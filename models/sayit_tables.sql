@@ -3,9 +3,11 @@ CREATE TABLE `users` (
                         `id` bigint(20) NOT NULL AUTO_INCREMENT,
                         `user_id` bigint(20) NOT NULL,
                         `username` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
-                        `password` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+                        `password` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
                         `email` varchar(64) COLLATE utf8mb4_general_ci,
                         `gender` tinyint(4) NOT NULL DEFAULT '0',
+                        `bio` varchar(256) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+                        `avatar` varchar(256) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
                         `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
                         `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE
 CURRENT_TIMESTAMP,
@@ -17,9 +19,11 @@ CURRENT_TIMESTAMP,
 DROP TABLE IF EXISTS `community`;
 CREATE TABLE `community` (
                              `id` int(11) NOT NULL AUTO_INCREMENT,
-                             `community_id` int(10) unsigned NOT NULL,
+                             `community_id` bigint(20) NOT NULL,
                              `community_name` varchar(128) COLLATE utf8mb4_general_ci NOT NULL,
                              `introduction` varchar(256) COLLATE utf8mb4_general_ci NOT NULL,
+                             `creator_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '创建者的用户id，预置社区为 0',
+                             `subscriber_count` bigint(20) NOT NULL DEFAULT '0' COMMENT '订阅人数',
                              `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
                              `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
                              PRIMARY KEY (`id`),
@@ -40,6 +44,28 @@ INSERT INTO `community` (`community_id`, `community_name`, `introduction`) VALUE
                                                                                (9, 'DigitalNomad', '数字游民生活方式社区，分享远程工作、旅行经验和装备推荐'),
                                                                                (10, 'PlantParents', '植物养护交流社区，分享种植经验、病虫害防治和绿植搭配');
 
+DROP TABLE IF EXISTS `community_moderator`;
+CREATE TABLE `community_moderator` (
+                             `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                             `community_id` bigint(20) NOT NULL COMMENT '社区id',
+                             `user_id` bigint(20) NOT NULL COMMENT '版主的用户id',
+                             `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                             PRIMARY KEY (`id`),
+                             UNIQUE KEY `idx_community_user` (`community_id`, `user_id`),
+                             KEY `idx_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+DROP TABLE IF EXISTS `community_subscription`;
+CREATE TABLE `community_subscription` (
+                             `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                             `community_id` bigint(20) NOT NULL COMMENT '社区id',
+                             `user_id` bigint(20) NOT NULL COMMENT '订阅者的用户id',
+                             `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                             PRIMARY KEY (`id`),
+                             UNIQUE KEY `idx_community_user` (`community_id`, `user_id`),
+                             KEY `idx_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
 DROP TABLE IF EXISTS `post`;
 CREATE TABLE `post` (
                         `id` bigint(20) NOT NULL AUTO_INCREMENT,
@@ -49,10 +75,57 @@ CREATE TABLE `post` (
                         `author_id` bigint(20) NOT NULL COMMENT '作者的用户id',
                         `community_id` bigint(20) NOT NULL COMMENT '所属社区',
                         `status` tinyint(4) NOT NULL DEFAULT '1' COMMENT '帖子状态',
+                        `comment_count` bigint(20) NOT NULL DEFAULT '0' COMMENT '评论数',
+                        `up_votes` bigint(20) NOT NULL DEFAULT '0' COMMENT '赞成票数',
+                        `down_votes` bigint(20) NOT NULL DEFAULT '0' COMMENT '反对票数',
+                        `score` double NOT NULL DEFAULT '0' COMMENT '热度分数',
+                        `vote_archived` tinyint(1) NOT NULL DEFAULT '0' COMMENT '投票结果是否已归档',
+                        `pinned` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否被版主置顶',
+                        `locked` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否被版主锁定，锁定后不能评论',
                         `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
                         `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
                         PRIMARY KEY (`id`),
                         UNIQUE KEY `idx_post_id` (`post_id`),
                         KEY `idx_author_id` (`author_id`),
-                        KEY `idx_community_id` (`community_id`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
\ No newline at end of file
+                        KEY `idx_community_id` (`community_id`),
+                        KEY `idx_community_pinned` (`community_id`, `pinned`),
+                        KEY `idx_create_time` (`create_time`),
+                        KEY `idx_score` (`score`),
+                        FULLTEXT KEY `idx_ft_title_content` (`title`, `content`) WITH PARSER ngram COMMENT '全文搜索，ngram 分词支持中文'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+DROP TABLE IF EXISTS `comment`;
+CREATE TABLE `comment` (
+                        `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                        `comment_id` bigint(20) NOT NULL COMMENT '评论id',
+                        `post_id` bigint(20) NOT NULL COMMENT '所属帖子id',
+                        `parent_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '回复的评论id，0 表示直接评论帖子',
+                        `root_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '所属一级评论id，一级评论为 0',
+                        `author_id` bigint(20) NOT NULL COMMENT '评论者的用户id',
+                        `content` varchar(1024) COLLATE utf8mb4_general_ci NOT NULL COMMENT '内容',
+                        `status` tinyint(4) NOT NULL DEFAULT '1' COMMENT '评论状态',
+                        `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+                        `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+                        PRIMARY KEY (`id`),
+                        UNIQUE KEY `idx_comment_id` (`comment_id`),
+                        KEY `idx_post_parent` (`post_id`, `parent_id`),
+                        KEY `idx_root_id` (`root_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+DROP TABLE IF EXISTS `outbox`;
+CREATE TABLE `outbox` (
+                        `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                        `event_type` varchar(64) COLLATE utf8mb4_general_ci NOT NULL COMMENT '事件类型，如 post.created',
+                        `aggregate_id` bigint(20) NOT NULL COMMENT '事件所属的业务数据id，如帖子id',
+                        `payload` text COLLATE utf8mb4_general_ci NOT NULL COMMENT '事件内容，JSON 格式',
+                        `status` tinyint(4) NOT NULL DEFAULT '0' COMMENT '0 等待处理，1 超过重试次数',
+                        `attempts` int(11) NOT NULL DEFAULT '0' COMMENT '已尝试处理的次数',
+                        `next_retry_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '下次处理的时间',
+                        `last_error` varchar(512) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '最近一次处理失败的原因',
+                        `claim_token` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '正在处理该事件的认领标识',
+                        `claim_expire_time` timestamp NULL DEFAULT NULL COMMENT '认领的过期时间，过期后其他实例可以重新认领',
+                        `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                        PRIMARY KEY (`id`),
+                        KEY `idx_status_next_retry` (`status`, `next_retry_time`),
+                        KEY `idx_aggregate_status` (`aggregate_id`, `status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
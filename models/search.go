@@ -0,0 +1,16 @@
+package models
+
+// SearchPostItem 搜索结果中的一项
+type SearchPostItem struct {
+	PostListItem
+	Content        string  `json:"-"`                        // 帖子全文，用于生成摘要片段
+	Relevance      float64 `json:"relevance"`                // MySQL 全文索引给出的相关度
+	TitleHighlight string  `json:"title_highlight" gorm:"-"` // 标题，命中的词用 <em></em> 包裹，已做 HTML 转义
+	Snippet        string  `json:"snippet" gorm:"-"`         // 内容中命中位置附近的片段，格式同 TitleHighlight
+}
+
+// SearchResult 搜索接口的响应
+type SearchResult struct {
+	Total int64             `json:"total"`
+	List  []*SearchPostItem `json:"list"`
+}
@@ -1,9 +1,42 @@
 package models
 
+import "time"
+
 type User struct {
-	UserID   int64  `gorm:"user_id"`
-	Username string `gorm:"username"`
-	Password string `gorm:"password"`
+	UserID     int64     `gorm:"column:user_id"`
+	Username   string    `gorm:"column:username"`
+	Password   string    `gorm:"column:password"`
+	Email      *string   `gorm:"column:email"`
+	Gender     int8      `gorm:"column:gender"`
+	Bio        string    `gorm:"column:bio"`
+	Avatar     string    `gorm:"column:avatar"`
+	CreateTime time.Time `gorm:"column:create_time;autoCreateTime"`
+	UpdateTime time.Time `gorm:"column:update_time;autoUpdateTime"`
+
+	Token        string `gorm:"-"`
+	RefreshToken string `gorm:"-"`
+}
+
+func (User) TableName() string {
+	return "users"
+}
+
+// 性别
+const (
+	GenderUnknown int8 = 0
+	GenderMale    int8 = 1
+	GenderFemale  int8 = 2
+)
 
-	Token string
+// UserProfile 用户主页信息
+type UserProfile struct {
+	UserID    int64     `json:"user_id,string"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email,omitempty"` // 只有本人可见
+	Gender    int8      `json:"gender"`
+	Bio       string    `json:"bio"`
+	Avatar    string    `json:"avatar"`
+	JoinTime  time.Time `json:"join_time"`
+	PostCount int64     `json:"post_count"` // 发帖数
+	Karma     int64     `json:"karma"`      // 声望：所有帖子获得的赞成票减去反对票
 }
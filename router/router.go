@@ -4,37 +4,93 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/namelyzz/sayit/controller"
 	"github.com/namelyzz/sayit/middlewares"
+	"github.com/namelyzz/sayit/utils/api"
 	"net/http"
 )
 
-func SetupRouter(mode string) *gin.Engine {
+// SetupRouter 创建路由，trustedProxies 为可信的反向代理，为空时不信任任何代理
+func SetupRouter(mode string, trustedProxies []string) (*gin.Engine, error) {
 	r := gin.New()
-	r.Use(middlewares.GinLogger(), middlewares.GinRecovery(true))
+	// 限流和登录锁定按 c.ClientIP() 计数，gin 默认信任所有代理，客户端可以伪造 X-Forwarded-For
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	// MetricsMiddleware 放在 GinRecovery 之前，panic 被恢复为 500 之后仍会计入指标
+	r.Use(middlewares.GinLogger(), middlewares.MetricsMiddleware(), middlewares.GinRecovery(true))
+
+	// 健康检查，供负载均衡和容器编排使用
+	r.GET("/healthz", controller.HealthzHandler) // 存活
+	r.GET("/readyz", controller.ReadyzHandler)   // 就绪，检查 MySQL、Redis
+
+	// 签名公钥，按惯例放在 /.well-known 下
+	r.GET("/.well-known/jwks.json", controller.JWKSHandler)
 
 	v1 := r.Group("/api/v1")
 
 	// 用户模块
-	v1.POST("/signup", controller.SignupHandler) // 注册
-	v1.POST("/login", controller.LoginHandler)   // 登录
+	auth := v1.Group("", middlewares.RateLimitMiddleware(middlewares.RateLimitGroupAuth))
+	{
+		auth.POST("/signup", controller.SignupHandler)        // 注册
+		auth.POST("/login", controller.LoginHandler)          // 登录
+		auth.POST("/refresh", controller.RefreshTokenHandler) // 刷新 token
+	}
+	// 游客也能访问；登录用户携带 token 时，可以在自己的主页看到邮箱，在帖子列表中看到自己的投票
+	users := v1.Group("/users/:id", middlewares.OptionalJWTAuthMiddleware())
+	{
+		users.GET("", controller.UserProfileHandler)        // 用户主页
+		users.GET("/posts", controller.UserPostListHandler) // 用户发布的帖子
+	}
 
 	v1.Use(middlewares.JWTAuthMiddleware()) // 应用JWT认证中间件
 
 	{
+		v1.POST("/logout", controller.LogoutHandler)        // 退出当前会话
+		v1.POST("/logout_all", controller.LogoutAllHandler) // 退出所有会话
+		v1.PATCH("/me", controller.UpdateProfileHandler)    // 修改个人资料
+		v1.GET("/me/votes", controller.MyVoteListHandler)   // 我的投票记录
+
 		v1.GET("/community", controller.CommunityHandler)
 		v1.GET("/community/:id", controller.CommunityDetailHandler)
+		v1.POST("/community", controller.CreateCommunityHandler)
+		v1.PUT("/community/:id", controller.UpdateCommunityHandler)
+		v1.POST("/community/:id/moderators", controller.AddModeratorHandler)
+		v1.POST("/community/:id/subscribe", controller.SubscribeCommunityHandler)
+		v1.DELETE("/community/:id/subscribe", controller.UnsubscribeCommunityHandler)
 
-		v1.POST("/create_post", controller.CreatePostHandler)
+		// 版主管理社区内的帖子
+		moderator := v1.Group("/community/:id", middlewares.CommunityModeratorMiddleware())
+		{
+			moderator.POST("/post/:post_id/pin", controller.PinPostHandler)
+			moderator.DELETE("/post/:post_id/pin", controller.UnpinPostHandler)
+			moderator.POST("/post/:post_id/lock", controller.LockPostHandler)
+			moderator.DELETE("/post/:post_id/lock", controller.UnlockPostHandler)
+			moderator.DELETE("/post/:post_id", controller.RemovePostHandler)
+		}
+
+		v1.POST("/create_post",
+			middlewares.RateLimitMiddleware(middlewares.RateLimitGroupPost),
+			middlewares.IdempotencyMiddleware(),
+			controller.CreatePostHandler)
 		v1.GET("/post_detail/:id", controller.GetPostDetailHandler)
 		v1.GET("/posts", controller.GetPostListHandler)
+		v1.GET("/feed", controller.GetFeedHandler)  // 订阅社区的帖子
+		v1.GET("/search", controller.SearchHandler) // 全文搜索帖子
+		v1.PUT("/post/:id", controller.UpdatePostHandler)
+		v1.DELETE("/post/:id", controller.DeletePostHandler)
+
+		v1.POST("/post/:id/comments", controller.CreateCommentHandler)
+		v1.GET("/post/:id/comments", controller.GetCommentListHandler)
+		v1.GET("/comment/:id/replies", controller.GetCommentRepliesHandler) // 一级评论下的全部回复
 
-		v1.POST("/vote", controller.PostVoteController)
+		v1.POST("/vote",
+			middlewares.RateLimitMiddleware(middlewares.RateLimitGroupVote),
+			middlewares.IdempotencyMiddleware(),
+			controller.PostVoteController)
 	}
 
 	r.NoRoute(func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"msg": "404 Not Found",
-		})
+		api.ResponseErrorWithStatus(c, http.StatusNotFound, api.CodeNotFound, nil)
 	})
 
-	return r
+	return r, nil
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"github.com/namelyzz/sayit/config"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReadTimeout     = 10 * time.Second
+	defaultWriteTimeout    = 30 * time.Second
+	defaultIdleTimeout     = 120 * time.Second
+	defaultShutdownTimeout = 30 * time.Second
+	defaultMetricsAddr     = "127.0.0.1:9091"
+)
+
+// newHTTPServer 创建带超时设置的 http.Server，避免慢客户端一直占用连接
+func newHTTPServer(port int, handler http.Handler, cfg *config.ServerConfig) *http.Server {
+	srv := &http.Server{
+		Addr:         ":" + strconv.Itoa(port),
+		Handler:      handler,
+		ReadTimeout:  defaultReadTimeout,
+		WriteTimeout: defaultWriteTimeout,
+		IdleTimeout:  defaultIdleTimeout,
+	}
+	if cfg != nil {
+		if cfg.ReadTimeout > 0 {
+			srv.ReadTimeout = time.Duration(cfg.ReadTimeout) * time.Second
+		}
+		if cfg.WriteTimeout > 0 {
+			srv.WriteTimeout = time.Duration(cfg.WriteTimeout) * time.Second
+		}
+		if cfg.IdleTimeout > 0 {
+			srv.IdleTimeout = time.Duration(cfg.IdleTimeout) * time.Second
+		}
+	}
+	return srv
+}
+
+// newMetricsServer 创建只提供 /metrics 的 http.Server，监听单独的内网地址，指标不会通过业务端口暴露到公网
+func newMetricsServer(cfg *config.ServerConfig) *http.Server {
+	addr := defaultMetricsAddr
+	if cfg != nil && cfg.MetricsAddr != "" {
+		addr = cfg.MetricsAddr
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  defaultReadTimeout,
+		WriteTimeout: defaultWriteTimeout,
+		IdleTimeout:  defaultIdleTimeout,
+	}
+}
+
+func shutdownTimeout(cfg *config.ServerConfig) time.Duration {
+	if cfg != nil && cfg.ShutdownTimeout > 0 {
+		return time.Duration(cfg.ShutdownTimeout) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+// workerGroup 一组后台任务，共用一个 ctx，停止时取消 ctx 并等待全部任务退出
+type workerGroup struct {
+	name   string
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// startWorkers 启动一组后台任务，每个任务都应在 ctx 被取消后尽快返回
+func startWorkers(name string, workers ...func(ctx context.Context)) *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &workerGroup{name: name, cancel: cancel}
+	for _, w := range workers {
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			w(ctx)
+		}()
+	}
+	return g
+}
+
+// stop 取消这组任务并等待其退出，最多等到 ctx 结束，超时后不再等待正在执行的任务
+func (g *workerGroup) stop(ctx context.Context) {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		zap.L().Info("workers stopped", zap.String("group", g.name))
+	case <-ctx.Done():
+		zap.L().Warn("workers did not stop before shutdown deadline", zap.String("group", g.name))
+	}
+}
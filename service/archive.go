@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"github.com/namelyzz/sayit/config"
+	"github.com/namelyzz/sayit/dao/mysql"
+	"github.com/namelyzz/sayit/dao/redis"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultVoteArchiveInterval  = 10 * time.Minute
+	defaultVoteArchiveBatchSize = 100
+	defaultVoteSyncInterval     = 30 * time.Second
+	defaultVoteSyncBatchSize    = 200
+)
+
+// runPeriodically 立即执行一次 fn，之后每隔 interval 执行一次，直到 ctx 被取消
+func runPeriodically(ctx context.Context, interval time.Duration, name string, fn func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := fn(ctx); err != nil {
+			zap.L().Error(name+" failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunVoteArchiver 周期性地归档投票期已结束的帖子，阻塞直到 ctx 被取消
+func RunVoteArchiver(ctx context.Context, cfg *config.WorkerConfig) {
+	interval := defaultVoteArchiveInterval
+	batchSize := defaultVoteArchiveBatchSize
+	if cfg != nil {
+		if cfg.VoteArchiveInterval > 0 {
+			interval = time.Duration(cfg.VoteArchiveInterval) * time.Second
+		}
+		if cfg.VoteArchiveBatchSize > 0 {
+			batchSize = cfg.VoteArchiveBatchSize
+		}
+	}
+
+	runPeriodically(ctx, interval, "ArchiveExpiredVotes", func(ctx context.Context) error {
+		return ArchiveExpiredVotes(ctx, batchSize)
+	})
+}
+
+// RunVoteSyncer 周期性地把有变化的投票数据同步到 MySQL，阻塞直到 ctx 被取消
+func RunVoteSyncer(ctx context.Context, cfg *config.WorkerConfig) {
+	interval := defaultVoteSyncInterval
+	batchSize := defaultVoteSyncBatchSize
+	if cfg != nil {
+		if cfg.VoteSyncInterval > 0 {
+			interval = time.Duration(cfg.VoteSyncInterval) * time.Second
+		}
+		if cfg.VoteSyncBatchSize > 0 {
+			batchSize = cfg.VoteSyncBatchSize
+		}
+	}
+
+	runPeriodically(ctx, interval, "SyncDirtyPostVotes", func(ctx context.Context) error {
+		return SyncDirtyPostVotes(ctx, batchSize)
+	})
+}
+
+/*
+SyncDirtyPostVotes 将投票有变化的帖子的票数和分数同步到 MySQL 的 post 表
+
+投票脚本会把帖子加入 vote:dirty 集合，这里分批取出再逐个写入 MySQL，
+同一帖子在两次同步之间无论被投多少次票，都只会写一次库。取出的帖子写入成功后才确认，
+写入失败的帖子会重新放回集合等待下次重试，进程崩溃时未确认的帖子在租期过后重新取出
+*/
+func SyncDirtyPostVotes(ctx context.Context, batchSize int) error {
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		postIDs, err := redis.ClaimVoteDirtyPosts(ctx, int64(batchSize))
+		if err != nil {
+			return err
+		}
+		if len(postIDs) == 0 {
+			return nil
+		}
+
+		for i, postIDStr := range postIDs {
+			if err = syncPostVote(ctx, postIDStr); err != nil {
+				if markErr := redis.MarkVoteDirtyPosts(ctx, postIDs[i:]...); markErr != nil {
+					zap.L().Error("redis.MarkVoteDirtyPosts failed", zap.Strings("post_ids", postIDs[i:]), zap.Error(markErr))
+				}
+				return err
+			}
+			if err = redis.AckVoteSyncedPost(ctx, postIDStr); err != nil {
+				zap.L().Error("redis.AckVoteSyncedPost failed", zap.String("post_id", postIDStr), zap.Error(err))
+			}
+		}
+
+		if len(postIDs) < batchSize {
+			return nil
+		}
+	}
+}
+
+func syncPostVote(ctx context.Context, postIDStr string) error {
+	postID, err := strconv.ParseInt(postIDStr, 10, 64)
+	if err != nil {
+		zap.L().Warn("invalid post id in redis", zap.String("post_id", postIDStr))
+		return nil
+	}
+
+	up, down, score, err := redis.GetPostVoteStats(ctx, postIDStr)
+	if err != nil {
+		return err
+	}
+	if err = mysql.SyncPostVote(postID, up, down, score); err != nil {
+		return err
+	}
+
+	// 投票时已更新过排行榜，这里再算一次，纠正更新失败或重建排行榜时遗漏的分数
+	return refreshPostRanks(ctx, postIDStr)
+}
+
+/*
+ArchiveExpiredVotes 归档投票期已结束的帖子
+
+按发帖时间从归档进度（cursor）开始分批扫描 post:time，对每个帖子：
+ 1. 统计 post:voted:<id> 中的赞成票、反对票以及 post:score 中的分数，写入 MySQL
+ 2. 删除 post:voted:<id>
+
+每处理完一批才推进 cursor。进程在任意位置崩溃后重跑，最多重复处理最后一批，
+而 mysql.ArchivePostVote 只会写入一次，所以重复执行是安全的
+*/
+func ArchiveExpiredVotes(ctx context.Context, batchSize int) (err error) {
+	cursor, err := redis.GetVoteArchiveCursor(ctx)
+	if err != nil {
+		return err
+	}
+
+	// cursor 是闭区间下界，offset 用来跳过上一批中发帖时间恰好等于 cursor 的帖子
+	var offset int64
+	for {
+		if err = ctx.Err(); err != nil {
+			return nil
+		}
+
+		posts, err := redis.GetVoteExpiredPosts(ctx, cursor, offset, int64(batchSize))
+		if err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			return nil
+		}
+
+		for _, z := range posts {
+			postID, ok := z.Member.(string)
+			if !ok {
+				continue
+			}
+			if err = archivePostVote(ctx, postID); err != nil {
+				return err
+			}
+		}
+
+		last := posts[len(posts)-1].Score
+		var same int64
+		for i := len(posts) - 1; i >= 0 && posts[i].Score == last; i-- {
+			same++
+		}
+		if last == cursor {
+			offset += same
+		} else {
+			offset = same
+		}
+
+		cursor = last
+		if err = redis.SetVoteArchiveCursor(ctx, cursor); err != nil {
+			return err
+		}
+	}
+}
+
+func archivePostVote(ctx context.Context, postIDStr string) error {
+	postID, err := strconv.ParseInt(postIDStr, 10, 64)
+	if err != nil {
+		zap.L().Warn("invalid post id in redis", zap.String("post_id", postIDStr))
+		return nil
+	}
+
+	if err = archivePostVoteStats(ctx, postID); err != nil {
+		return err
+	}
+
+	// 无论本次是否写入，MySQL 中都已有最终结果，投票记录可以删除
+	return redis.DeletePostVoted(ctx, postIDStr)
+}
+
+// archivePostVoteStats 把帖子在 Redis 中的最终票数和分数写入 MySQL，已归档过的帖子不会被覆盖
+func archivePostVoteStats(ctx context.Context, postID int64) error {
+	up, down, score, err := redis.GetPostVoteStats(ctx, strconv.FormatInt(postID, 10))
+	if err != nil {
+		return err
+	}
+
+	archived, err := mysql.ArchivePostVote(postID, up, down, score)
+	if err != nil {
+		return err
+	}
+	if archived {
+		zap.L().Debug("post vote archived",
+			zap.Int64("post_id", postID),
+			zap.Int64("up_votes", up),
+			zap.Int64("down_votes", down),
+			zap.Float64p("score", score))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"github.com/namelyzz/sayit/dao/mysql"
+	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/utils/api"
+	"github.com/namelyzz/sayit/utils/snowflake"
+	"go.uber.org/zap"
+)
+
+/*
+CreateComment 发表评论或回复评论
+
+评论的层级关系：
+  - 直接评论帖子：ParentID = 0, RootID = 0，即一级评论
+  - 回复某条评论：ParentID = 被回复的评论，RootID = 被回复评论所属的一级评论
+    这样无论回复嵌套多深，同一棵回复树都可以通过 root_id 一次查出，再在内存中按 parent_id 组装
+*/
+func CreateComment(postID, userID int64, p *models.ParamComment) (comment *models.Comment, err error) {
+	post, err := mysql.GetPostByID(postID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有正常状态的帖子允许评论
+	if post.Status != models.PostStatusNormal {
+		return nil, api.ErrorPostNotExist
+	}
+	// 被版主锁定的帖子不能再评论
+	if post.Locked {
+		return nil, api.ErrorPostLocked
+	}
+
+	comment = &models.Comment{
+		CommentID: snowflake.GenID(),
+		PostID:    postID,
+		AuthorID:  userID,
+		Content:   p.Content,
+	}
+
+	if p.ParentID != 0 {
+		parent, err := mysql.GetCommentByID(p.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		// 被回复的评论必须属于同一个帖子
+		if parent.PostID != postID || parent.Status != 1 {
+			return nil, api.ErrorCommentNotExist
+		}
+
+		comment.ParentID = parent.CommentID
+		comment.RootID = parent.RootID
+		if parent.RootID == 0 {
+			comment.RootID = parent.CommentID
+		}
+	}
+
+	if err = mysql.CreateComment(comment); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// treeRepliesPerRoot 评论树中每条一级评论最多附带的回复数
+const treeRepliesPerRoot = 10
+
+// GetCommentTree 按一级评论分页获取帖子的评论树，每条一级评论只附带最早的 treeRepliesPerRoot 条回复
+func GetCommentTree(postID int64, p *models.ParamCommentList) (res *models.CommentList, err error) {
+	// 已删除的帖子不再返回评论，与 CreateComment 一致
+	if err = checkPostVisible(postID); err != nil {
+		return nil, err
+	}
+
+	roots, total, err := mysql.GetRootComments(postID, p.Page, p.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	res = &models.CommentList{
+		Total:    total,
+		Comments: make([]*models.CommentNode, 0, len(roots)),
+	}
+	if len(roots) == 0 {
+		return res, nil
+	}
+
+	rootIDs := make([]int64, 0, len(roots))
+	nodes := make(map[int64]*models.CommentNode, len(roots))
+	for _, r := range roots {
+		node := &models.CommentNode{CommentItem: r, Replies: []*models.CommentNode{}}
+		nodes[r.CommentID] = node
+		rootIDs = append(rootIDs, r.CommentID)
+		res.Comments = append(res.Comments, node)
+	}
+
+	replies, err := mysql.GetRepliesByRootIDs(rootIDs, treeRepliesPerRoot)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := mysql.CountRepliesByRootIDs(rootIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, rootID := range rootIDs {
+		nodes[rootID].ReplyCount = counts[rootID]
+	}
+
+	// 回复按时间正序返回，父评论一定先于子评论出现，所以一次遍历即可挂载到父节点下
+	for _, r := range replies {
+		node := &models.CommentNode{CommentItem: r, Replies: []*models.CommentNode{}}
+		nodes[r.CommentID] = node
+
+		parent, ok := nodes[r.ParentID]
+		if !ok {
+			zap.L().Warn("comment parent not found",
+				zap.Int64("comment_id", r.CommentID),
+				zap.Int64("parent_id", r.ParentID))
+			continue
+		}
+		parent.Replies = append(parent.Replies, node)
+	}
+
+	return res, nil
+}
+
+// GetCommentReplies 分页获取一级评论下的回复，用于展开评论树中没有附带的回复
+func GetCommentReplies(rootID int64, p *models.ParamCommentList) (res *models.CommentReplyList, err error) {
+	root, err := mysql.GetCommentByID(rootID)
+	if err != nil {
+		return nil, err
+	}
+	// 只有正常状态的一级评论才有回复列表
+	if root.Status != 1 || root.ParentID != 0 {
+		return nil, api.ErrorCommentNotExist
+	}
+	if err = checkPostVisible(root.PostID); err != nil {
+		return nil, err
+	}
+
+	replies, total, err := mysql.GetRepliesByRootID(rootID, p.Page, p.Size)
+	if err != nil {
+		return nil, err
+	}
+	if replies == nil {
+		replies = []*models.CommentItem{}
+	}
+	return &models.CommentReplyList{Total: total, Replies: replies}, nil
+}
+
+// checkPostVisible 帖子不存在或已删除时返回 ErrorPostNotExist
+func checkPostVisible(postID int64) error {
+	post, err := mysql.GetPostByID(postID)
+	if err != nil {
+		return err
+	}
+	if post.Status != models.PostStatusNormal {
+		return api.ErrorPostNotExist
+	}
+	return nil
+}
@@ -1,8 +1,11 @@
 package service
 
 import (
+	"context"
 	"github.com/namelyzz/sayit/dao/mysql"
 	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/utils/api"
+	"github.com/namelyzz/sayit/utils/snowflake"
 )
 
 func GetCommunityList() ([]*models.Community, error) {
@@ -12,3 +15,103 @@ func GetCommunityList() ([]*models.Community, error) {
 func GetCommunityDetailByID(id int64) (*models.CommunityDetail, error) {
 	return mysql.GetCommunityDetailByID(id)
 }
+
+// CreateCommunity 创建社区，创建者自动成为版主
+func CreateCommunity(userID int64, p *models.ParamCreateCommunity) (detail *models.CommunityDetail, err error) {
+	detail = &models.CommunityDetail{
+		ID:           snowflake.GenID(),
+		Name:         p.Name,
+		Introduction: p.Introduction,
+		CreatorID:    userID,
+	}
+	if err = mysql.CreateCommunity(detail); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// getOwnCommunity 获取社区并校验当前用户是否为创建者
+func getOwnCommunity(communityID, userID int64) (detail *models.CommunityDetail, err error) {
+	detail, err = mysql.GetCommunityDetailByID(communityID)
+	if err != nil {
+		return nil, err
+	}
+	if detail.CreatorID == 0 || detail.CreatorID != userID {
+		return nil, api.ErrorNoPermission
+	}
+	return detail, nil
+}
+
+// UpdateCommunity 创建者修改社区简介
+func UpdateCommunity(communityID, userID int64, p *models.ParamUpdateCommunity) (err error) {
+	if _, err = getOwnCommunity(communityID, userID); err != nil {
+		return err
+	}
+	return mysql.UpdateCommunityIntroduction(communityID, p.Introduction)
+}
+
+// AddCommunityModerator 创建者为社区添加版主
+func AddCommunityModerator(communityID, userID int64, p *models.ParamAddModerator) (err error) {
+	if _, err = getOwnCommunity(communityID, userID); err != nil {
+		return err
+	}
+	if _, err = mysql.GetUserByID(p.UserID); err != nil {
+		return err
+	}
+	return mysql.AddCommunityModerator(communityID, p.UserID)
+}
+
+// IsCommunityModerator 判断用户是否为社区的版主
+func IsCommunityModerator(communityID, userID int64) (bool, error) {
+	return mysql.IsCommunityModerator(communityID, userID)
+}
+
+// getCommunityPost 获取社区内正常状态的帖子，版主只能管理自己社区的帖子
+func getCommunityPost(communityID, postID int64) (post *models.Post, err error) {
+	post, err = mysql.GetPostByID(postID)
+	if err != nil {
+		return nil, err
+	}
+	if post.Status != models.PostStatusNormal || post.CommunityID != communityID {
+		return nil, api.ErrorPostNotExist
+	}
+	return post, nil
+}
+
+// PinPost 版主置顶或取消置顶帖子，置顶的帖子显示在社区列表第一页的最前面
+func PinPost(communityID, postID int64, pinned bool) (err error) {
+	if _, err = getCommunityPost(communityID, postID); err != nil {
+		return err
+	}
+	return mysql.SetPostPinned(postID, pinned)
+}
+
+// LockPost 版主锁定或解锁帖子，锁定后不能再评论
+func LockPost(communityID, postID int64, locked bool) (err error) {
+	if _, err = getCommunityPost(communityID, postID); err != nil {
+		return err
+	}
+	return mysql.SetPostLocked(postID, locked)
+}
+
+// RemovePost 版主移除帖子，与作者删除帖子一样是软删除
+func RemovePost(ctx context.Context, communityID, postID int64) (err error) {
+	post, err := getCommunityPost(communityID, postID)
+	if err != nil {
+		return err
+	}
+	return deletePost(ctx, post)
+}
+
+// SubscribeCommunity 订阅社区
+func SubscribeCommunity(communityID, userID int64) (err error) {
+	if _, err = mysql.GetCommunityDetailByID(communityID); err != nil {
+		return err
+	}
+	return mysql.SubscribeCommunity(communityID, userID)
+}
+
+// UnsubscribeCommunity 取消订阅社区
+func UnsubscribeCommunity(communityID, userID int64) (err error) {
+	return mysql.UnsubscribeCommunity(communityID, userID)
+}
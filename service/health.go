@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"github.com/namelyzz/sayit/dao/mysql"
+	"github.com/namelyzz/sayit/dao/redis"
+	"github.com/namelyzz/sayit/models"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// readinessCheckTimeout 单个依赖检查的超时，需要小于负载均衡健康检查的超时
+const readinessCheckTimeout = 2 * time.Second
+
+// readinessChecks 就绪检查依赖的服务
+var readinessChecks = map[string]func(ctx context.Context) error{
+	"mysql": mysql.Ping,
+	"redis": redis.Ping,
+}
+
+// CheckReadiness 并发检查所有依赖，返回每个依赖的状态和耗时，任一依赖不可用时整体为 down
+func CheckReadiness(ctx context.Context) *models.Readiness {
+	res := &models.Readiness{
+		Status:       models.HealthStatusUp,
+		Dependencies: make(map[string]*models.DependencyHealth, len(readinessChecks)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range readinessChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := checkDependency(ctx, name, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Dependencies[name] = h
+			if h.Status != models.HealthStatusUp {
+				res.Status = models.HealthStatusDown
+			}
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+func checkDependency(ctx context.Context, name string, check func(ctx context.Context) error) *models.DependencyHealth {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	h := &models.DependencyHealth{
+		Status:    models.HealthStatusUp,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		zap.L().Warn("readiness check failed", zap.String("dependency", name), zap.Error(err))
+		h.Status = models.HealthStatusDown
+	}
+	return h
+}
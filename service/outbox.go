@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/namelyzz/sayit/config"
+	"github.com/namelyzz/sayit/dao/mysql"
+	"github.com/namelyzz/sayit/dao/redis"
+	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/utils/api"
+	"github.com/namelyzz/sayit/utils/ranking"
+	"github.com/namelyzz/sayit/utils/security"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultOutboxRelayInterval  = 1 * time.Second
+	defaultOutboxRelayBatchSize = 100
+
+	// outboxMaxAttempts 超过该次数后不再重试，事件保留在表中等待人工处理
+	outboxMaxAttempts = 10
+	// outboxMaxBackoff 重试间隔按 2^n 秒增长，最长不超过该值
+	outboxMaxBackoff = 5 * time.Minute
+	// outboxClaimLease 认领的有效期，必须大于处理一批事件的时间，持有者崩溃后事件在此之后被重新认领
+	outboxClaimLease = 1 * time.Minute
+	// outboxDrainTimeout 退出前最后一次处理剩余事件的最长时间
+	outboxDrainTimeout = 5 * time.Second
+)
+
+// outboxHandler 处理一种发件箱事件，必须是幂等的：同一个事件可能因为重试或认领过期而执行多次
+type outboxHandler func(ctx context.Context, event *models.OutboxEvent) error
+
+var outboxHandlers = map[string]outboxHandler{
+	models.OutboxEventPostCreated: handlePostCreated,
+	models.OutboxEventPostUpdated: handlePostUpdated,
+	models.OutboxEventPostDeleted: handlePostDeleted,
+}
+
+// RunOutboxRelay 周期性地处理发件箱中的事件，ctx 被取消后再处理一次剩余的事件，最多等待 outboxDrainTimeout
+func RunOutboxRelay(ctx context.Context, cfg *config.WorkerConfig) {
+	interval := defaultOutboxRelayInterval
+	batchSize := defaultOutboxRelayBatchSize
+	if cfg != nil {
+		if cfg.OutboxRelayInterval > 0 {
+			interval = time.Duration(cfg.OutboxRelayInterval) * time.Second
+		}
+		if cfg.OutboxRelayBatchSize > 0 {
+			batchSize = cfg.OutboxRelayBatchSize
+		}
+	}
+
+	runPeriodically(ctx, interval, "RelayOutboxEvents", func(ctx context.Context) error {
+		return RelayOutboxEvents(ctx, batchSize)
+	})
+
+	// HTTP 请求和其他后台任务在此之前已经停止，处理它们最后写入的事件
+	drainCtx, cancel := context.WithTimeout(context.Background(), outboxDrainTimeout)
+	defer cancel()
+	if err := RelayOutboxEvents(drainCtx, batchSize); err != nil {
+		zap.L().Error("drain outbox events failed", zap.Error(err))
+	}
+}
+
+/*
+RelayOutboxEvents 按写入顺序认领并处理已到处理时间的发件箱事件
+
+认领保证同一个事件不会被多个实例或业务请求同时处理，同一个帖子的事件按写入顺序依次处理。
+处理成功的事件直接删除；失败的事件记录原因，按 2^n 秒退避后重试，超过 outboxMaxAttempts 次后不再重试。
+一个事件失败不影响同一批中其他事件的处理
+*/
+func RelayOutboxEvents(ctx context.Context, batchSize int) error {
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		token, err := security.RandomToken(16)
+		if err != nil {
+			return err
+		}
+		events, err := mysql.ClaimPendingOutboxEvents(batchSize, token, outboxClaimLease)
+		if err != nil {
+			return err
+		}
+
+		var failed int
+		for _, event := range events {
+			if err = applyOutboxEvent(ctx, event, token); err != nil {
+				failed++
+				markOutboxEventFailed(event, token, err)
+			}
+		}
+
+		// 失败的事件已推迟到之后再处理，如果整批都失败，说明 Redis 可能不可用，等下个周期再试
+		if len(events) < batchSize || failed == len(events) {
+			return nil
+		}
+	}
+}
+
+// applyOutboxEvent 执行已认领的事件对应的操作，成功后删除事件
+func applyOutboxEvent(ctx context.Context, event *models.OutboxEvent, token string) error {
+	handler, ok := outboxHandlers[event.EventType]
+	if !ok {
+		return fmt.Errorf("unknown outbox event type: %s", event.EventType)
+	}
+	if err := handler(ctx, event); err != nil {
+		return err
+	}
+	return mysql.DeleteOutboxEvent(event.ID, token)
+}
+
+/*
+tryApplyOutboxEvent 业务事务提交后立即尝试处理一次事件，让新帖子马上出现在列表中
+
+先认领事件，事件已被后台任务认领，或者同一个帖子还有更早的事件未处理时，交给后台任务按顺序处理。
+失败时只记录日志，事件仍在发件箱中，由后台任务重试，不影响接口返回成功
+*/
+func tryApplyOutboxEvent(ctx context.Context, event *models.OutboxEvent) {
+	token, err := security.RandomToken(16)
+	if err != nil {
+		zap.L().Warn("generate outbox claim token failed", zap.Error(err))
+		return
+	}
+	claimed, err := mysql.ClaimOutboxEvent(event, token, outboxClaimLease)
+	if err != nil {
+		zap.L().Warn("claim outbox event failed, will retry in background",
+			zap.Int64("event_id", event.ID),
+			zap.Error(err))
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	if err = applyOutboxEvent(ctx, event, token); err != nil {
+		zap.L().Warn("apply outbox event failed, will retry in background",
+			zap.Int64("event_id", event.ID),
+			zap.String("event_type", event.EventType),
+			zap.Error(err))
+		markOutboxEventFailed(event, token, err)
+	}
+}
+
+func markOutboxEventFailed(event *models.OutboxEvent, token string, cause error) {
+	attempts := event.Attempts + 1
+	giveUp := attempts >= outboxMaxAttempts
+
+	backoff := outboxMaxBackoff
+	if attempts < 20 {
+		backoff = min(time.Duration(1<<attempts)*time.Second, outboxMaxBackoff)
+	}
+
+	if giveUp {
+		zap.L().Error("outbox event exceeded max attempts",
+			zap.Int64("event_id", event.ID),
+			zap.String("event_type", event.EventType),
+			zap.Int64("aggregate_id", event.AggregateID),
+			zap.Error(cause))
+	}
+
+	if err := mysql.MarkOutboxEventFailed(event.ID, token, attempts, time.Now().Add(backoff), cause.Error(), giveUp); err != nil {
+		zap.L().Error("mysql.MarkOutboxEventFailed failed", zap.Int64("event_id", event.ID), zap.Error(err))
+	}
+}
+
+func decodePostEventPayload(event *models.OutboxEvent) (*models.PostEventPayload, error) {
+	payload := new(models.PostEventPayload)
+	if err := json.Unmarshal([]byte(event.Payload), payload); err != nil {
+		return nil, errors.Wrapf(err, "decode payload of outbox event %d", event.ID)
+	}
+	return payload, nil
+}
+
+// handlePostCreated 将新帖子加入 Redis 排行榜和社区集合
+// 事件可能在帖子被删除之后才重试成功，所以先确认帖子仍是正常状态，避免把已删除的帖子重新加回去
+func handlePostCreated(ctx context.Context, event *models.OutboxEvent) error {
+	payload, err := decodePostEventPayload(event)
+	if err != nil {
+		return err
+	}
+
+	post, err := mysql.GetPostByID(payload.PostID)
+	if errors.Is(err, api.ErrorPostNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if post.Status != models.PostStatusNormal {
+		return nil
+	}
+
+	ranks := ranking.Scores(0, 0, payload.CreateTime)
+	return redis.CreatePost(ctx, payload.PostID, payload.CommunityID, payload.Score, ranks)
+}
+
+// handlePostUpdated 帖子的标题和内容目前没有保存在 Redis 中，无需处理
+func handlePostUpdated(ctx context.Context, event *models.OutboxEvent) error {
+	return nil
+}
+
+/*
+handlePostDeleted 将帖子从 Redis 排行榜和社区集合中移除，并清理它的投票数据
+
+顺序不能颠倒：先移出时间榜，之后的投票都会被拒绝；再把最终票数归档到 MySQL；最后才删除投票记录。
+中途失败时事件会重试，每一步都可以重复执行
+*/
+func handlePostDeleted(ctx context.Context, event *models.OutboxEvent) error {
+	payload, err := decodePostEventPayload(event)
+	if err != nil {
+		return err
+	}
+	if err = redis.DeletePost(ctx, payload.PostID, payload.CommunityID); err != nil {
+		return err
+	}
+	if err = archivePostVoteStats(ctx, payload.PostID); err != nil {
+		return err
+	}
+	return redis.DeletePostVotes(ctx, strconv.FormatInt(payload.PostID, 10))
+}
@@ -5,9 +5,13 @@ import (
 	"github.com/namelyzz/sayit/dao/mysql"
 	"github.com/namelyzz/sayit/dao/redis"
 	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/utils/api"
 	"github.com/namelyzz/sayit/utils/conv"
+	"github.com/namelyzz/sayit/utils/metrics"
+	"github.com/namelyzz/sayit/utils/ranking"
 	"github.com/namelyzz/sayit/utils/snowflake"
 	"go.uber.org/zap"
+	"strconv"
 	"time"
 )
 
@@ -38,13 +42,26 @@ func CreatePost(ctx context.Context, p *models.Post) (err error) {
 	now := time.Now()
 
 	p.CreateTime = now
-	err = mysql.CreatePost(p)
+	// MySQL 中的初始分数与 Redis 热度榜保持一致，之后由投票同步任务更新
+	p.Score = float64(now.Unix())
+
+	// Redis 的写入通过发件箱完成：事件与帖子在同一个事务中写入，帖子保存成功，Redis 的写入就一定会被执行
+	event, err := models.NewOutboxEvent(models.OutboxEventPostCreated, p.PostID, &models.PostEventPayload{
+		PostID:      p.PostID,
+		CommunityID: p.CommunityID,
+		CreateTime:  now.Unix(),
+		Score:       p.Score,
+	})
 	if err != nil {
 		return err
 	}
+	if err = mysql.CreatePost(p, event); err != nil {
+		return err
+	}
+	metrics.PostsCreated.Inc()
 
-	err = redis.CreatePost(ctx, p.PostID, p.CommunityID, float64(now.Unix()))
-	return err
+	tryApplyOutboxEvent(ctx, event)
+	return nil
 }
 
 func GetPostDetailByID(postID int64) (res *models.PostDetail, err error) {
@@ -55,6 +72,10 @@ func GetPostDetailByID(postID int64) (res *models.PostDetail, err error) {
 			zap.Error(err))
 		return nil, err
 	}
+	// 已删除的帖子对外不可见
+	if post.Status == models.PostStatusDeleted {
+		return nil, api.ErrorPostNotExist
+	}
 
 	authorID := post.AuthorID
 	user, err := mysql.GetUserByID(authorID)
@@ -81,29 +102,309 @@ func GetPostDetailByID(postID int64) (res *models.PostDetail, err error) {
 	}, nil
 }
 
-func GetPostList(p *models.ParamPostList) (posts []*models.PostListItem, err error) {
-	return mysql.GetPostList(p)
+// getOwnPost 获取帖子并校验当前用户是否为作者
+func getOwnPost(postID, userID int64) (post *models.Post, err error) {
+	post, err = mysql.GetPostByID(postID)
+	if err != nil {
+		return nil, err
+	}
+	if post.Status == models.PostStatusDeleted {
+		return nil, api.ErrorPostNotExist
+	}
+	if post.AuthorID != userID {
+		return nil, api.ErrorNoPermission
+	}
+	return post, nil
 }
 
-func ListPosts(ctx context.Context, p *models.ParamPostList) (posts []*models.PostListItem, err error) {
-	// 简单查询: 无关键字，无用户名筛选
-	isSimpleQuery := p.UserName == "" && p.Keyword == ""
-	// 跨纬度冲突: 如果按热度排序，但是又指定了时间范围，redis 处理不了
-	isCrossDim := p.SortBy == models.SortFieldScore && (p.StartTime != nil || p.EndTime != nil)
+// UpdatePost 作者编辑帖子的标题和内容
+func UpdatePost(ctx context.Context, postID, userID int64, p *models.ParamUpdatePost) (err error) {
+	post, err := getOwnPost(postID, userID)
+	if err != nil {
+		return err
+	}
+
+	event, err := models.NewOutboxEvent(models.OutboxEventPostUpdated, postID, &models.PostEventPayload{
+		PostID:      postID,
+		CommunityID: post.CommunityID,
+	})
+	if err != nil {
+		return err
+	}
+	if err = mysql.UpdatePost(postID, p.Title, p.Content, event); err != nil {
+		return err
+	}
+
+	tryApplyOutboxEvent(ctx, event)
+	return nil
+}
+
+/*
+DeletePost 作者删除帖子
+
+MySQL 中只把 status 改为已删除，不物理删除数据行；
+Redis 中则要把帖子从各个排行榜和社区集合中移除，否则 ListPosts 走 Redis 时仍会拿到它的 ID，这一步通过发件箱完成
+*/
+func DeletePost(ctx context.Context, postID, userID int64) (err error) {
+	post, err := getOwnPost(postID, userID)
+	if err != nil {
+		return err
+	}
+	return deletePost(ctx, post)
+}
+
+// deletePost 软删除帖子，并通过发件箱把帖子移出 Redis，作者删除和版主移除共用
+func deletePost(ctx context.Context, post *models.Post) (err error) {
+	event, err := models.NewOutboxEvent(models.OutboxEventPostDeleted, post.PostID, &models.PostEventPayload{
+		PostID:      post.PostID,
+		CommunityID: post.CommunityID,
+	})
+	if err != nil {
+		return err
+	}
+	if err = mysql.DeletePost(post.PostID, event); err != nil {
+		return err
+	}
+
+	tryApplyOutboxEvent(ctx, event)
+	return nil
+}
+
+/*
+ListPosts 获取帖子列表，并附带每个帖子的实时投票数据以及当前用户的投票
+
+简单查询优先走 Redis 排行榜，复杂查询或 Redis 出错时走 MySQL。
+每页取满时返回下一页的游标，客户端可以继续用 page/size 翻页，也可以改用游标翻页。
+社区列表的第一页最前面是该社区的置顶帖子，之后按排序正常翻页，置顶的帖子不会再出现
+*/
+func ListPosts(ctx context.Context, userID int64, p *models.ParamPostList) (res *models.PostList, err error) {
+	var pinned []*models.PostListItem
+	if isCommunityListing(p) {
+		if pinned, err = mysql.GetPinnedPosts(p); err != nil {
+			return nil, err
+		}
+		for _, post := range pinned {
+			p.PinnedIDs = append(p.PinnedIDs, post.PostID)
+		}
+	}
+
+	res, err = listPosts(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(pinned) > 0 && p.After == nil && p.Page == 1 {
+		res.List = append(pinned, res.List...)
+	}
+
+	attachVoteData(ctx, userID, res.List)
+	return res, nil
+}
+
+// isCommunityListing 是否为浏览某个社区的帖子列表，只有此时才把置顶的帖子放在最前面，
+// 按作者、关键字、时间范围筛选的查询仍按排序返回
+func isCommunityListing(p *models.ParamPostList) bool {
+	return p.CommunityID > 0 && len(p.CommunityIDs) == 0 && p.AuthorID == 0 &&
+		p.UserName == "" && p.Keyword == "" && p.StartTime == nil && p.EndTime == nil &&
+		*p.Status == int(models.PostStatusNormal)
+}
+
+// ListUserPosts 获取指定用户发布的帖子，用户的帖子不在 Redis 排行榜中单独维护，直接查 MySQL
+func ListUserPosts(ctx context.Context, viewerID, authorID int64, p *models.ParamPostList) (res *models.PostList, err error) {
+	if _, err = mysql.GetUserByID(authorID); err != nil {
+		return nil, err
+	}
+
+	p.AuthorID = authorID
+	return ListPosts(ctx, viewerID, p)
+}
+
+/*
+ListFeed 获取首页 feed：合并当前用户订阅的所有社区的帖子
+
+与 ListPosts 一样，能走 Redis 时合并各个社区的排行缓存，否则按社区 id 列表查 MySQL。没有订阅任何社区时返回空列表
+*/
+func ListFeed(ctx context.Context, userID int64, p *models.ParamPostList) (res *models.PostList, err error) {
+	communityIDs, err := mysql.GetSubscribedCommunityIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(communityIDs) == 0 {
+		return &models.PostList{List: []*models.PostListItem{}}, nil
+	}
+
+	p.CommunityID = 0
+	p.CommunityIDs = communityIDs
+	return ListPosts(ctx, userID, p)
+}
+
+/*
+ListUserVotes 获取用户的投票记录，按投票时间倒序
+
+投票记录来自 Redis 中的 user:voted:<uid> 索引，帖子信息从 MySQL 批量查询，已删除的帖子不返回
+*/
+func ListUserVotes(ctx context.Context, userID int64, p *models.ParamUserVoteList) (res *models.UserVoteList, err error) {
+	offset := int64((p.Page - 1) * p.Size)
+	votes, total, err := redis.GetUserVotes(ctx, strconv.FormatInt(userID, 10), offset, int64(p.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	res = &models.UserVoteList{Total: total, List: make([]*models.UserVoteItem, 0, len(votes))}
+	if len(votes) == 0 {
+		return res, nil
+	}
+
+	postIDs := make([]int64, 0, len(votes))
+	for _, v := range votes {
+		id, _ := strconv.ParseInt(v.PostID, 10, 64)
+		postIDs = append(postIDs, id)
+	}
+	posts, err := mysql.GetPostListByIDs(postIDs)
+	if err != nil {
+		return nil, err
+	}
+	attachVoteData(ctx, 0, posts)
+
+	postMap := make(map[int64]*models.PostListItem, len(posts))
+	for _, post := range posts {
+		postMap[post.PostID] = post
+	}
+	var deleted []string
+	for i, v := range votes {
+		post, ok := postMap[postIDs[i]]
+		if !ok {
+			deleted = append(deleted, v.PostID)
+			continue
+		}
+		post.VoteDirection = v.Direction
+		res.List = append(res.List, &models.UserVoteItem{PostListItem: post, VoteTime: v.VoteTime})
+	}
+
+	// 删除帖子时会清理投票用户的记录，清理失败时残留的已删除帖子不计入总数，并顺便从投票历史中移除
+	if len(deleted) > 0 {
+		res.Total -= int64(len(deleted))
+		if err = redis.RemoveUserVotes(ctx, strconv.FormatInt(userID, 10), deleted...); err != nil {
+			zap.L().Warn("redis.RemoveUserVotes failed", zap.Int64("user_id", userID), zap.Error(err))
+		}
+	}
+	return res, nil
+}
+
+func listPosts(ctx context.Context, p *models.ParamPostList) (res *models.PostList, err error) {
+	// 简单查询: 无关键字，无用户筛选，只查正常状态的帖子（Redis 中只保存正常状态的帖子）
+	isSimpleQuery := p.UserName == "" && p.AuthorID == 0 && p.Keyword == "" && *p.Status == int(models.PostStatusNormal)
+	// 跨纬度冲突: 如果按热度或排序策略排序，但是又指定了时间范围，redis 处理不了
+	isCrossDim := p.SortBy != models.SortFieldCreateTime && (p.StartTime != nil || p.EndTime != nil)
+	// Redis 中有排行榜的排序字段
+	hasZset := p.SortBy == models.SortFieldScore || p.SortBy == models.SortFieldCreateTime || p.SortBy.IsRank()
 
 	// 只有“简单查询”且“无维度冲突”才走 Redis
-	if (p.SortBy == models.SortFieldScore || p.SortBy == models.SortFieldCreateTime) && isSimpleQuery && !isCrossDim {
-		var ids []string
-		ids, err = redis.GetPostIDsInOrder(ctx, p)
+	if hasZset && isSimpleQuery && !isCrossDim {
+		zs, err := redis.GetPostIDsInOrder(ctx, p)
 		if err != nil {
 			zap.L().Warn("redis.GetPostIDsInOrder failed", zap.Error(err))
 			// 降级走 DB
-			return mysql.GetPostList(p)
+			return listPostsFromDB(p)
+		}
+
+		ids := make([]string, 0, len(zs))
+		for _, z := range zs {
+			if id, ok := z.Member.(string); ok {
+				ids = append(ids, id)
+			}
+		}
+
+		// 按 Redis 排行榜中的顺序返回
+		posts, err := mysql.GetPostListByIDs(conv.Strings2Int64s(ids))
+		if err != nil {
+			return nil, err
 		}
 
-		return mysql.GetPostListByIDs(conv.Strings2Int64s(ids))
+		res = &models.PostList{List: posts}
+		if len(zs) == p.Size {
+			last := zs[len(zs)-1]
+			lastID, _ := strconv.ParseInt(ids[len(ids)-1], 10, 64)
+			res.NextCursor = models.EncodePostCursor(&models.PostCursor{
+				SortBy: p.SortBy,
+				Order:  p.Order,
+				Value:  last.Score,
+				PostID: lastID,
+			})
+		}
+		return res, nil
 	}
 
 	// 复杂的查询，需要从 mysql 中获取
-	return mysql.GetPostList(p)
+	return listPostsFromDB(p)
+}
+
+func listPostsFromDB(p *models.ParamPostList) (res *models.PostList, err error) {
+	posts, err := mysql.GetPostList(p)
+	if err != nil {
+		return nil, err
+	}
+
+	res = &models.PostList{List: posts}
+	if len(posts) == p.Size {
+		last := posts[len(posts)-1]
+		cursor := &models.PostCursor{
+			SortBy: p.SortBy,
+			Order:  p.Order,
+			PostID: last.PostID,
+		}
+		switch p.SortBy {
+		case models.SortFieldScore:
+			cursor.Value = last.Score
+		case models.SortFieldHot, models.SortFieldBest, models.SortFieldControversial:
+			// 此时 LikeCount/DislikeCount 仍是 MySQL 中的票数，与排序所用的 SQL 表达式一致
+			s, _ := ranking.Get(p.SortBy)
+			cursor.Value = s.Score(last.LikeCount, last.DislikeCount, last.CreateTime.Unix())
+		case models.SortFieldUpdateTime:
+			cursor.Value = float64(last.UpdateTime.Unix())
+		default:
+			cursor.Value = float64(last.CreateTime.Unix())
+		}
+		res.NextCursor = models.EncodePostCursor(cursor)
+	}
+	return res, nil
+}
+
+/*
+attachVoteData 为帖子列表填充投票数据
+
+投票期内的帖子，票数以 Redis 中的投票记录为准（MySQL 中的票数由同步任务定期写入，会有延迟）；
+投票期结束并归档后，Redis 中的投票记录已被删除，票数直接使用 MySQL 中的归档结果，
+当前用户的投票则始终来自用户的投票记录 user:vote_dir，归档后同样可以显示。
+投票数据只是列表的附加信息，Redis 出错时只记录日志，仍然返回 MySQL 中的票数
+*/
+func attachVoteData(ctx context.Context, userID int64, posts []*models.PostListItem) {
+	if len(posts) == 0 {
+		return
+	}
+
+	postIDs := make([]string, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, strconv.FormatInt(post.PostID, 10))
+	}
+
+	userIDStr := ""
+	if userID > 0 {
+		userIDStr = strconv.FormatInt(userID, 10)
+	}
+
+	data, err := redis.GetPostsVoteData(ctx, postIDs, userIDStr)
+	if err != nil {
+		zap.L().Warn("redis.GetPostsVoteData failed", zap.Error(err))
+		return
+	}
+
+	for i, post := range posts {
+		// 用户的投票在归档后仍保留在 Redis 中
+		post.VoteDirection = data[i].Direction
+		if post.VoteArchived {
+			continue
+		}
+		post.LikeCount = data[i].UpVotes
+		post.DislikeCount = data[i].DownVotes
+	}
 }
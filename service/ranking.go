@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"github.com/namelyzz/sayit/dao/mysql"
+	"github.com/namelyzz/sayit/dao/redis"
+	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/utils/ranking"
+	"github.com/pkg/errors"
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRankRebuildBatchSize = 500
+	// rankRebuildLockTTL 重建排行榜的锁的有效期，持有锁的实例异常退出后，其他实例最晚在这之后可以重新执行
+	rankRebuildLockTTL = 30 * time.Minute
+)
+
+// refreshPostRanks 读取帖子最新的票数，重新计算它在各排序策略下的分数
+// 票数的读取和分数的写入在同一个 WATCH 事务中，并发投票时最后写入的总是最新票数对应的分数
+func refreshPostRanks(ctx context.Context, postID string) error {
+	return redis.UpdatePostRanks(ctx, postID, ranking.Scores)
+}
+
+// setPostRanks 按给定票数计算帖子在各排序策略下的分数并写入排行榜，用于投票期结束后按归档的票数写入，此时不会再有新的投票
+func setPostRanks(ctx context.Context, postID string, up, down int64) error {
+	createTime, err := redis.GetPostCreateTime(ctx, postID)
+	if errors.Is(err, goredis.Nil) {
+		// 帖子已被删除
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return redis.SetPostRanks(ctx, postID, ranking.Scores(up, down, createTime))
+}
+
+/*
+RebuildChangedRanks 重建公式有变化的排行榜，启动时调用
+
+每个排行榜在 Redis 中记录了构建时所用公式的版本，与当前策略的 Version 不一致（包括从未构建过）时，
+按 post_id 分批扫描 MySQL 中所有正常状态的帖子重新计算分数。
+分数写入单独的重建 key，线上的排行榜在重建期间保持旧公式的分数不变，全部写完后再原子地替换，并更新版本号。
+重建中途失败或进程退出，版本号不会更新，下次启动时会重新执行。
+多个实例同时启动时，只有拿到锁的实例执行重建
+*/
+func RebuildChangedRanks(ctx context.Context) error {
+	var changed []ranking.Strategy
+	for _, sortBy := range models.RankSortFields {
+		s, ok := ranking.Get(sortBy)
+		if !ok {
+			continue
+		}
+
+		version, err := redis.GetRankVersion(ctx, sortBy)
+		if err != nil {
+			return err
+		}
+		if version != s.Version() {
+			zap.L().Info("rank formula changed, rebuilding",
+				zap.String("sort_by", string(sortBy)),
+				zap.String("old_version", version),
+				zap.String("new_version", s.Version()))
+			changed = append(changed, s)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	locked, err := redis.AcquireRankRebuildLock(ctx, rankRebuildLockTTL)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		zap.L().Info("ranks are being rebuilt by another instance")
+		return nil
+	}
+	defer func() {
+		if err := redis.ReleaseRankRebuildLock(context.Background()); err != nil {
+			zap.L().Warn("redis.ReleaseRankRebuildLock failed", zap.Error(err))
+		}
+	}()
+
+	// 留出一点余量，重建开始前后刚发布、还没写入 MySQL 的帖子替换时从线上的排行榜中补上
+	since := time.Now().Add(-time.Minute).Unix()
+	for _, s := range changed {
+		if err = redis.StartRankRebuild(ctx, s.Name()); err != nil {
+			return err
+		}
+	}
+
+	if err = rebuildRanks(ctx, changed, defaultRankRebuildBatchSize); err != nil {
+		return err
+	}
+
+	for _, s := range changed {
+		if err = redis.FinishRankRebuild(ctx, s.Name(), since); err != nil {
+			return err
+		}
+		if err = redis.SetRankVersion(ctx, s.Name(), s.Version()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rebuildRanks(ctx context.Context, strategies []ranking.Strategy, batchSize int) error {
+	var afterID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		posts, err := mysql.ScanNormalPosts(afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			return nil
+		}
+
+		// 投票期内的帖子以 Redis 中的实时票数为准，已归档的帖子使用 MySQL 中的最终结果
+		postIDs := make([]string, 0, len(posts))
+		for _, post := range posts {
+			postIDs = append(postIDs, strconv.FormatInt(post.PostID, 10))
+		}
+		votes, err := redis.GetPostsVoteData(ctx, postIDs, "")
+		if err != nil {
+			return err
+		}
+
+		for _, s := range strategies {
+			zs := make([]goredis.Z, 0, len(posts))
+			for i, post := range posts {
+				up, down := post.UpVotes, post.DownVotes
+				if !post.VoteArchived {
+					up, down = votes[i].UpVotes, votes[i].DownVotes
+				}
+				zs = append(zs, goredis.Z{
+					Score:  s.Score(up, down, post.CreateTime.Unix()),
+					Member: postIDs[i],
+				})
+			}
+			if err = redis.AddRankRebuildPosts(ctx, s.Name(), zs); err != nil {
+				return err
+			}
+		}
+
+		afterID = posts[len(posts)-1].PostID
+		if len(posts) < batchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"github.com/namelyzz/sayit/dao/mysql"
+	"github.com/namelyzz/sayit/dao/redis"
+	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/utils/conv"
+	"github.com/namelyzz/sayit/utils/ranking"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+const defaultRedisRebuildBatchSize = 500
+
+// RedisRebuildReport 重建结果，记录 Redis 与 MySQL 之间的差异
+type RedisRebuildReport struct {
+	Migrated            int64 `json:"migrated"`              // 转换为补零格式的旧 member 数
+	Scanned             int64 `json:"scanned"`               // MySQL 中正常状态的帖子数
+	MissingTime         int64 `json:"missing_time"`          // 不在时间榜中的帖子数，即各个 feed 中都看不到的帖子
+	MissingScore        int64 `json:"missing_score"`         // 不在热度榜中的帖子数
+	MissingCommunity    int64 `json:"missing_community"`     // 不在所属社区集合中的帖子数
+	VotesLost           int64 `json:"votes_lost"`            // 投票期内、MySQL 中有票数但 Redis 中没有投票记录的帖子数
+	Orphaned            int64 `json:"orphaned"`              // Redis 中有、MySQL 中不存在或已删除的帖子数，已从排行榜中移除，已删除的帖子归档票数后清理投票记录
+	OrphanedInCommunity int64 `json:"orphaned_in_community"` // 社区集合中 MySQL 已不存在或已删除的帖子数，已从集合中移除
+	UserVotesRestored   int64 `json:"user_votes_restored"`   // 根据帖子的投票记录补写的用户投票历史数
+}
+
+/*
+RebuildRedisFromMySQL 根据 MySQL 中的帖子重建 Redis 中的时间榜、热度榜、各排序策略的排行榜和社区集合
+
+用于 Redis 数据丢失，或者 mysql.CreatePost 成功而 redis.CreatePost 失败之后的修复：
+ 0. 将排行榜和社区集合中旧格式的 member 转换为补零的帖子 ID，见 MigrateRedisPostMembers
+ 1. 按 post_id 分批扫描 MySQL 中正常状态的帖子，补写 Redis 中缺失的索引。热度榜使用 post.score 列，
+    排序策略的分数按 post 表中持久化的票数计算；已存在的分数不会被覆盖
+ 2. 遍历时间榜、热度榜、各排序策略的排行榜和社区集合，移除 MySQL 中已不存在或已删除的帖子及其投票记录，
+    已删除的帖子先归档票数
+ 3. 根据剩余帖子的投票记录 post:voted:<id> 补写投票用户的投票历史，见 redis.BackfillUserVotes
+
+每个用户的投票只保存在 Redis 中，丢失后无法从 MySQL 恢复。投票期内这类帖子的分数可以从 post.score 恢复，
+但之后的同步会以 Redis 中剩余的投票记录为准，报告中的 VotesLost 记录了受影响的帖子数。
+补写使用 ZADD NX / SADD，可以在线上运行，也可以重复执行
+*/
+func RebuildRedisFromMySQL(ctx context.Context, batchSize int) (report *RedisRebuildReport, err error) {
+	if batchSize <= 0 {
+		batchSize = defaultRedisRebuildBatchSize
+	}
+	report = new(RedisRebuildReport)
+
+	if report.Migrated, err = migratePostMembers(ctx, batchSize); err != nil {
+		return report, err
+	}
+	if err = restorePostIndexes(ctx, batchSize, report); err != nil {
+		return report, err
+	}
+	if err = removeOrphanedPosts(ctx, batchSize, report); err != nil {
+		return report, err
+	}
+	if report.UserVotesRestored, err = backfillUserVotes(ctx, batchSize); err != nil {
+		return report, err
+	}
+
+	zap.L().Info("rebuild redis from mysql finished", zap.Any("report", report))
+	return report, nil
+}
+
+/*
+MigrateRedisPostMembers 将排行榜和社区集合中旧格式的 member 转换为补零的帖子 ID，已转换过时直接返回
+
+补零之后同分的帖子在 zset 中按帖子 ID 的数值排列，分页不需要再取出整组同分的帖子排序。
+启动时、开始处理请求之前执行，否则旧帖子在投票脚本看来不在时间榜中，无法投票。
+滚动发布期间旧版本的实例仍可能写入旧格式的 member，之后用 -rebuild-redis 再转换一次
+*/
+func MigrateRedisPostMembers(ctx context.Context) error {
+	migrated, err := redis.IsPostMemberMigrated(ctx)
+	if err != nil || migrated {
+		return err
+	}
+
+	n, err := migratePostMembers(ctx, defaultRedisRebuildBatchSize)
+	if err != nil {
+		return err
+	}
+	zap.L().Info("migrate redis post members finished", zap.Int64("migrated", n))
+	return redis.SetPostMemberMigrated(ctx)
+}
+
+func migratePostMembers(ctx context.Context, batchSize int) (migrated int64, err error) {
+	scanAll := func(migrate func(cursor uint64, count int64) (int64, uint64, error)) error {
+		var cursor uint64
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			n, next, err := migrate(cursor, int64(batchSize))
+			if err != nil {
+				return err
+			}
+			migrated += n
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+
+	for _, key := range redis.PostZsetKeys() {
+		err = scanAll(func(cursor uint64, count int64) (int64, uint64, error) {
+			return redis.MigrateZsetPostMembers(ctx, key, cursor, count)
+		})
+		if err != nil {
+			return migrated, err
+		}
+	}
+
+	communities, err := mysql.GetCommunityList()
+	if err != nil {
+		return migrated, err
+	}
+	for _, c := range communities {
+		communityID := c.ID
+		err = scanAll(func(cursor uint64, count int64) (int64, uint64, error) {
+			return redis.MigrateCommunityPostMembers(ctx, communityID, cursor, count)
+		})
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+func restorePostIndexes(ctx context.Context, batchSize int, report *RedisRebuildReport) error {
+	// 投票期为一周，与投票脚本保持一致
+	voteDeadline := time.Now().Add(-7 * 24 * time.Hour).Unix()
+
+	var afterID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		posts, err := mysql.ScanNormalPosts(afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			return nil
+		}
+		report.Scanned += int64(len(posts))
+
+		states, err := redis.GetPostIndexStates(ctx, posts)
+		if err != nil {
+			return err
+		}
+
+		var (
+			missing []*models.Post
+			ranks   []map[models.SortField]float64
+		)
+		for i, post := range posts {
+			state := states[i]
+			if !state.InTime {
+				report.MissingTime++
+			}
+			if !state.InScore {
+				report.MissingScore++
+			}
+			if !state.InCommunity {
+				report.MissingCommunity++
+			}
+			if !post.VoteArchived && post.CreateTime.Unix() > voteDeadline &&
+				post.UpVotes+post.DownVotes > 0 && !state.HasVoted {
+				report.VotesLost++
+			}
+
+			if !state.InTime || !state.InScore || !state.InCommunity {
+				missing = append(missing, post)
+				ranks = append(ranks, ranking.Scores(post.UpVotes, post.DownVotes, post.CreateTime.Unix()))
+			}
+		}
+
+		if len(missing) > 0 {
+			if err = redis.RestorePosts(ctx, missing, ranks); err != nil {
+				return err
+			}
+		}
+
+		afterID = posts[len(posts)-1].PostID
+		if len(posts) < batchSize {
+			return nil
+		}
+	}
+}
+
+/*
+removeOrphanedPosts 移除 Redis 中 MySQL 已不存在或已删除的帖子
+
+遍历时间榜、热度榜和各排序策略的排行榜，残留在任意一个 zset 中的帖子都会被找到，
+已删除的帖子先归档票数，再连同投票记录从 Redis 中清理；再遍历每个社区的集合，移除其中的这类帖子
+*/
+func removeOrphanedPosts(ctx context.Context, batchSize int, report *RedisRebuildReport) error {
+	for _, key := range redis.PostZsetKeys() {
+		err := scanOrphanedPosts(ctx, batchSize, func(cursor uint64, count int64) ([]string, uint64, error) {
+			return redis.ScanZsetPostIDs(ctx, key, cursor, count)
+		}, func(orphaned []string) error {
+			report.Orphaned += int64(len(orphaned))
+			if err := redis.RemoveOrphanedPosts(ctx, orphaned); err != nil {
+				return err
+			}
+			return clearOrphanedPostVotes(ctx, orphaned)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	communities, err := mysql.GetCommunityList()
+	if err != nil {
+		return err
+	}
+	for _, c := range communities {
+		communityID := c.ID
+		err = scanOrphanedPosts(ctx, batchSize, func(cursor uint64, count int64) ([]string, uint64, error) {
+			return redis.ScanCommunityPostIDs(ctx, communityID, cursor, count)
+		}, func(orphaned []string) error {
+			report.OrphanedInCommunity += int64(len(orphaned))
+			return redis.RemovePostsFromCommunity(ctx, communityID, orphaned)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillUserVotes 在清理孤立帖子之后执行，已删除帖子的投票记录已被清理，不会补写到用户的投票历史中
+func backfillUserVotes(ctx context.Context, batchSize int) (restored int64, err error) {
+	var cursor uint64
+	for {
+		if err = ctx.Err(); err != nil {
+			return restored, err
+		}
+		n, next, err := redis.BackfillUserVotes(ctx, cursor, int64(batchSize))
+		if err != nil {
+			return restored, err
+		}
+		restored += n
+		if next == 0 {
+			return restored, nil
+		}
+		cursor = next
+	}
+}
+
+/*
+clearOrphanedPostVotes 清理孤立帖子的投票数据
+
+MySQL 中仍有记录（已删除）的帖子先把票数归档到 MySQL，再删除投票记录，与 handlePostDeleted 的顺序一致：
+它的 post.deleted 事件可能还在发件箱中等待重试，之后归档时帖子已经归档过，不会用空的投票记录把票数覆盖为 0。
+MySQL 中不存在的帖子没有需要保留的票数，直接删除
+*/
+func clearOrphanedPostVotes(ctx context.Context, postIDs []string) error {
+	existingIDs, err := mysql.GetExistingPostIDs(conv.Strings2Int64s(postIDs))
+	if err != nil {
+		return err
+	}
+	existing := make(map[int64]struct{}, len(existingIDs))
+	for _, id := range existingIDs {
+		existing[id] = struct{}{}
+	}
+
+	for _, idStr := range postIDs {
+		if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+			if _, ok := existing[id]; ok {
+				if err = archivePostVoteStats(ctx, id); err != nil {
+					return err
+				}
+			}
+		}
+		if err = redis.DeletePostVotes(ctx, idStr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanOrphanedPosts 用 scan 分批遍历一个 Redis 索引中的帖子，把 MySQL 中已不存在或已删除的帖子交给 remove 处理
+func scanOrphanedPosts(ctx context.Context, batchSize int,
+	scan func(cursor uint64, count int64) ([]string, uint64, error),
+	remove func(orphaned []string) error) error {
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		postIDs, next, err := scan(cursor, int64(batchSize))
+		if err != nil {
+			return err
+		}
+
+		if len(postIDs) > 0 {
+			orphaned, err := findOrphanedPosts(postIDs)
+			if err != nil {
+				return err
+			}
+			if len(orphaned) > 0 {
+				if err = remove(orphaned); err != nil {
+					return err
+				}
+			}
+		}
+
+		// SCAN 期间移除元素是安全的，遍历开始时就存在的元素都会被返回
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// findOrphanedPosts 找出 MySQL 中不存在或已删除的帖子
+func findOrphanedPosts(postIDs []string) (orphaned []string, err error) {
+	normalIDs, err := mysql.GetNormalPostIDs(conv.Strings2Int64s(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	normal := make(map[int64]struct{}, len(normalIDs))
+	for _, id := range normalIDs {
+		normal[id] = struct{}{}
+	}
+
+	for _, idStr := range postIDs {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			orphaned = append(orphaned, idStr)
+			continue
+		}
+		if _, ok := normal[id]; !ok {
+			orphaned = append(orphaned, idStr)
+		}
+	}
+	return orphaned, nil
+}
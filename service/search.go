@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"github.com/namelyzz/sayit/dao/mysql"
+	"github.com/namelyzz/sayit/models"
+	"github.com/namelyzz/sayit/utils/search"
+)
+
+// SearchSnippetLength 搜索结果中内容片段的长度（字符数）
+const SearchSnippetLength = 120
+
+/*
+SearchPosts 全文搜索帖子
+
+MySQL 全文索引负责召回和按相关度排序，这里为每条结果生成高亮的标题和内容片段，并填充投票数据。
+ngram 分词会把搜索词切成更短的词元去匹配，召回的帖子中不一定出现完整的搜索词，此时片段从内容开头截取
+*/
+func SearchPosts(ctx context.Context, userID int64, p *models.ParamSearch) (res *models.SearchResult, err error) {
+	items, total, err := mysql.SearchPosts(p)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := search.Terms(p.Q)
+	posts := make([]*models.PostListItem, 0, len(items))
+	for _, item := range items {
+		item.TitleHighlight = search.Highlight(item.Title, terms)
+		item.Snippet = search.Snippet(item.Content, terms, SearchSnippetLength)
+		posts = append(posts, &item.PostListItem)
+	}
+	attachVoteData(ctx, userID, posts)
+
+	if items == nil {
+		items = []*models.SearchPostItem{}
+	}
+	return &models.SearchResult{Total: total, List: items}, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"github.com/namelyzz/sayit/dao/redis"
+	"github.com/namelyzz/sayit/utils/api"
+	"github.com/namelyzz/sayit/utils/jwt"
+	"github.com/namelyzz/sayit/utils/security"
+	"time"
+)
+
+/*
+issueTokens 为用户签发一对 access token 和 refresh token
+
+  - access token 是短期有效的 JWT，携带 jti 和用户当前的 token 版本号
+  - refresh token 是随机字符串，保存在 Redis 中，只能使用一次，每次刷新都会换发新的 refresh token
+*/
+func issueTokens(ctx context.Context, userID int64, username string) (accessToken, refreshToken string, err error) {
+	version, err := redis.GetUserTokenVersion(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err = jwt.CreateAccessToken(userID, username, version)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err = security.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = redis.SaveRefreshToken(ctx, refreshToken, &redis.RefreshTokenData{
+		UserID:       userID,
+		Username:     username,
+		TokenVersion: version,
+	}, jwt.RefreshTokenTTL())
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// RefreshToken 使用 refresh token 换取新的 access token 和 refresh token，旧的 refresh token 随即失效
+func RefreshToken(ctx context.Context, refreshToken string) (accessToken, newRefreshToken string, err error) {
+	data, err := redis.TakeRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	if data == nil {
+		return "", "", api.ErrorInvalidToken
+	}
+
+	// 用户退出所有设备后，之前签发的 refresh token 也不能再使用
+	version, err := redis.GetUserTokenVersion(ctx, data.UserID)
+	if err != nil {
+		return "", "", err
+	}
+	if version != data.TokenVersion {
+		return "", "", api.ErrorInvalidToken
+	}
+
+	return issueTokens(ctx, data.UserID, data.Username)
+}
+
+// Logout 退出当前会话：吊销当前的 access token，并删除客户端提交的 refresh token
+func Logout(ctx context.Context, userID int64, jti string, expiresAt time.Time, refreshToken string) error {
+	if err := redis.RevokeAccessToken(ctx, jti, time.Until(expiresAt)); err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+	return redis.DeleteRefreshToken(ctx, refreshToken, userID)
+}
+
+// LogoutAll 退出所有会话：递增用户的 token 版本号，之前签发的 access token 和 refresh token 全部失效
+func LogoutAll(ctx context.Context, userID int64) error {
+	_, err := redis.IncrUserTokenVersion(ctx, userID)
+	return err
+}
+
+// CheckAccessToken 检查 JWT 校验通过的 access token 是否已被吊销
+func CheckAccessToken(ctx context.Context, claims *jwt.UserClaims) error {
+	ok, err := redis.IsAccessTokenValid(ctx, claims.ID, claims.UserID, claims.TokenVersion)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return api.ErrorInvalidToken
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package service
 
 import (
+	"context"
 	"github.com/namelyzz/sayit/dao/mysql"
 	"github.com/namelyzz/sayit/models"
-	"github.com/namelyzz/sayit/utils/jwt"
+	"github.com/namelyzz/sayit/utils/api"
+	"github.com/namelyzz/sayit/utils/metrics"
 	"github.com/namelyzz/sayit/utils/snowflake"
+	"github.com/pkg/errors"
 )
 
 func SignUp(p *models.ParamSignUp) (err error) {
@@ -25,16 +28,67 @@ func SignUp(p *models.ParamSignUp) (err error) {
 	return mysql.InsertUser(user)
 }
 
-func Login(p *models.ParamLogin) (user *models.User, err error) {
+// Login 校验用户名和密码并签发 token，ip 为客户端 IP，用于登录失败保护
+func Login(ctx context.Context, p *models.ParamLogin, ip string) (user *models.User, err error) {
+	if err = checkLoginBlocked(ctx, p.Username, ip); err != nil {
+		metrics.Logins.WithLabelValues(metrics.LoginBlocked).Inc()
+		return nil, err
+	}
+
 	user = &models.User{Username: p.Username, Password: p.Password}
 	if err = mysql.Login(user); err != nil {
+		if errors.Is(err, api.ErrorUserNotExist) || errors.Is(err, api.ErrorInvalidLogin) {
+			metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+			recordLoginFailure(ctx, p.Username, ip)
+		}
 		return nil, err
 	}
+	metrics.Logins.WithLabelValues(metrics.LoginSuccess).Inc()
+	resetLoginFailures(ctx, p.Username)
 
-	token, err := jwt.CreateJWTToken(user.UserID, user.Username)
+	user.Token, user.RefreshToken, err = issueTokens(ctx, user.UserID, user.Username)
 	if err != nil {
 		return nil, err
 	}
-	user.Token = token
 	return user, nil
 }
+
+// GetUserProfile 获取用户主页，邮箱只对本人可见
+func GetUserProfile(userID, viewerID int64) (*models.UserProfile, error) {
+	profile, err := mysql.GetUserProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if viewerID != userID {
+		profile.Email = ""
+	}
+	return profile, nil
+}
+
+// UpdateProfile 修改个人资料，返回修改后的资料
+func UpdateProfile(userID int64, p *models.ParamUpdateProfile) (*models.UserProfile, error) {
+	values := make(map[string]interface{})
+	if p.Bio != nil {
+		values["bio"] = *p.Bio
+	}
+	if p.Avatar != nil {
+		values["avatar"] = *p.Avatar
+	}
+	if p.Email != nil {
+		// 邮箱清空时存为 NULL
+		if *p.Email == "" {
+			values["email"] = nil
+		} else {
+			values["email"] = *p.Email
+		}
+	}
+	if p.Gender != nil {
+		values["gender"] = *p.Gender
+	}
+
+	if err := mysql.UpdateUserProfile(userID, values); err != nil {
+		return nil, err
+	}
+	return mysql.GetUserProfile(userID)
+}
@@ -5,7 +5,8 @@ import (
 	"github.com/namelyzz/sayit/dao/redis"
 	"github.com/namelyzz/sayit/models"
 	"github.com/namelyzz/sayit/utils/api"
-	"math"
+	"github.com/namelyzz/sayit/utils/metrics"
+	"go.uber.org/zap"
 	"strconv"
 )
 
@@ -29,41 +30,28 @@ VoteForPost 为帖子投票，每一票的分值是 432分
 	每个贴子自发表之日起一个星期之内允许用户投票，超过一个星期就不允许再投票了。
 		1. 到期之后将redis中保存的赞成票数及反对票数存储到mysql表中
 		2. 到期之后删除那个 KeyPostVotedZSetPF
+	以上两步由后台任务 RunVoteArchiver 定期完成
 */
 func VoteForPost(ctx context.Context, userID int64, p *models.ParamVote) (err error) {
-	postID := p.PostID
-
-	// 判断当前帖子是否可以投票，超过时间则不能再投票了
-	if !redis.IsPostCreatedWithinOneWeek(ctx, postID) {
-		return api.ErrorVoteTimeExpire
-	}
-
-	// 获取当前投票值, 验证投票有效性
 	userIDStr := strconv.FormatInt(userID, 10)
 
-	// 用户当前想要投的票：1(赞), 0(取消), -1(踩)
-	newVote := float64(p.Direction)
-
-	// 查询当前用户之前对该帖子的投票记录：1, 0, 或 -1
-	// 如果没投过票，GetPostVoteScore 应返回 0
-	curVote := redis.GetPostVoteScore(ctx, postID, userIDStr)
+	// 投票期检查、重复投票判断和分数更新都在 Redis 脚本中原子完成
+	res, err := redis.VoteForPost(ctx, userIDStr, p.PostID, p.Direction)
+	if err != nil {
+		return err
+	}
 
-	// 如果用户的新票值和旧票值一致，说明是重复操作，直接返回
-	if newVote == curVote {
+	switch res {
+	case redis.VoteResultExpired:
+		return api.ErrorVoteTimeExpire
+	case redis.VoteResultRepeated:
 		return api.ErrorVoteRepeated
 	}
+	metrics.VotesCast.WithLabelValues(metrics.VoteDirection(p.Direction)).Inc()
 
-	// 计算分数更新的方向 (operate) 和权重 (diff)
-	operate := 1
-	// 如果新值 < 旧值 (例如 1变0, 0变-1, 1变-1)，说明是在减少权重（向左移动），即减分
-	if newVote < curVote {
-		operate = -1
+	// 投票已经成功，排行榜更新失败只记录日志，帖子已被标记为待同步，同步任务会再次更新排行榜
+	if err = refreshPostRanks(ctx, p.PostID); err != nil {
+		zap.L().Warn("refreshPostRanks failed", zap.String("post_id", p.PostID), zap.Error(err))
 	}
-
-	// 计算票值的绝对差值，决定了基础分值的倍数
-	// 差值为 1：表示 新投/取消 (如 0->1, 1->0) -> 变动 432分
-	// 差值为 2：表示 反向改票 (如 -1->1, 1->-1) -> 变动 432*2 分
-	diff := math.Abs(newVote - curVote)
-
-	return redis.UpdatePostVote(ctx, userIDStr, postID, newVote, float64(operate), diff)
+	return nil
 }
@@ -12,6 +12,20 @@ const (
 
 	CodeNeedLogin
 	CodeInvalidToken
+
+	CodeNoPermission
+
+	CodeCommunityExist
+	CodePostLocked
+
+	CodeIdempotencyKeyReused
+	CodeRequestInProgress
+
+	CodeTooManyRequests
+	CodeLoginBlocked
+
+	CodeNotFound
+	CodeServiceNotReady
 )
 
 var codeMsgMap = map[ResCode]string{
@@ -24,6 +38,20 @@ var codeMsgMap = map[ResCode]string{
 
 	CodeNeedLogin:    "需要登录",
 	CodeInvalidToken: "无效的token",
+
+	CodeNoPermission: "无权限操作",
+
+	CodeCommunityExist: "社区名称已存在",
+	CodePostLocked:     "帖子已锁定，不能评论",
+
+	CodeIdempotencyKeyReused: "Idempotency-Key 已用于内容不同的请求",
+	CodeRequestInProgress:    "相同的请求正在处理中，请稍后重试",
+
+	CodeTooManyRequests: "请求过于频繁，请稍后再试",
+	CodeLoginBlocked:    "登录失败次数过多，请稍后再试",
+
+	CodeNotFound:        "404 Not Found",
+	CodeServiceNotReady: "服务暂不可用",
 }
 
 func (c ResCode) Msg() string {
@@ -1,14 +1,47 @@
 package api
 
-import "github.com/pkg/errors"
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"time"
+)
 
 var (
 	ErrorUserExist    = errors.New("用户已存在")
 	ErrorUserNotExist = errors.New("用户不存在")
 	ErrorUserNotLogin = errors.New("用户未登录")
+	ErrorInvalidToken = errors.New("无效的token")
 	ErrorInvalidLogin = errors.New("用户名或密码错误")
 	ErrorInvalidID    = errors.New("无效的ID")
 
+	ErrorPostNotExist    = errors.New("帖子不存在")
+	ErrorCommentNotExist = errors.New("评论不存在")
+	ErrorNoPermission    = errors.New("无权限操作")
+	ErrorPostLocked      = errors.New("帖子已锁定")
+
+	ErrorCommunityExist = errors.New("社区已存在")
+
 	ErrorVoteTimeExpire = errors.New("投票时间已过")
 	ErrorVoteRepeated   = errors.New("重复的投票")
+
+	ErrorLoginBlocked = errors.New("登录失败次数过多")
 )
+
+// LoginBlockedError 登录因多次失败被暂停，RetryAfter 为需要等待的时长，errors.Is 可以匹配 ErrorLoginBlocked
+type LoginBlockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	return fmt.Sprintf("%s，请在 %d 秒后重试", ErrorLoginBlocked.Error(), e.Seconds())
+}
+
+func (e *LoginBlockedError) Is(target error) bool {
+	return target == ErrorLoginBlocked
+}
+
+// Seconds 需要等待的秒数，向上取整
+func (e *LoginBlockedError) Seconds() int64 {
+	return int64(math.Ceil(e.RetryAfter.Seconds()))
+}
@@ -2,9 +2,14 @@ package api
 
 import (
 	"github.com/gin-gonic/gin"
+	"time"
 )
 
-const CtxUserIDKey = "userID"
+const (
+	CtxUserIDKey      = "userID"
+	CtxTokenIDKey     = "tokenID"
+	CtxTokenExpireKey = "tokenExpire"
+)
 
 // GetCurrentUserID 获取当前登录的用户ID
 func GetCurrentUserID(c *gin.Context) (userID int64, err error) {
@@ -20,3 +25,13 @@ func GetCurrentUserID(c *gin.Context) (userID int64, err error) {
 	}
 	return
 }
+
+// GetCurrentToken 获取当前请求所用 access token 的 jti 和过期时间
+func GetCurrentToken(c *gin.Context) (jti string, expiresAt time.Time, err error) {
+	jti = c.GetString(CtxTokenIDKey)
+	expiresAt = c.GetTime(CtxTokenExpireKey)
+	if jti == "" {
+		err = ErrorUserNotLogin
+	}
+	return
+}
@@ -36,6 +36,24 @@ func ResponseErrorWithMsg(c *gin.Context, code ResCode, msg interface{}) {
 	})
 }
 
+// ResponseErrorWithData 错误信息之外还需要返回数据，如需要等待的时长
+func ResponseErrorWithData(c *gin.Context, code ResCode, msg interface{}, data any) {
+	c.JSON(http.StatusOK, &ResponseData{
+		Code: code,
+		Msg:  msg,
+		Data: data,
+	})
+}
+
+// ResponseErrorWithStatus 需要让网关、负载均衡按 HTTP 语义处理的错误（如限流、404、未就绪），同时返回对应的状态码
+func ResponseErrorWithStatus(c *gin.Context, status int, code ResCode, data any) {
+	c.JSON(status, &ResponseData{
+		Code: code,
+		Msg:  code.Msg(),
+		Data: data,
+	})
+}
+
 func ResponseSuccess(c *gin.Context, data any) {
 	c.JSON(http.StatusOK, &ResponseData{
 		Code: CodeSuccess,
@@ -3,52 +3,83 @@ package jwt
 import (
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/namelyzz/sayit/utils/security"
 	"github.com/pkg/errors"
 	"time"
 )
 
-// 在实际应用中，密钥必须保密且足够复杂。而且推荐配置化，不要和代码放在一起
-var jwtSecret = []byte("Faiz555WuMingKe")
-
 // UserClaims 必须嵌入 jwt.RegisteredClaims
 type UserClaims struct {
-	UserID               int64  `json:"user_id"`
-	Username             string `json:"username"`
-	jwt.RegisteredClaims        // 包含 iss, exp, iat 等标准 Claims
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	// TokenVersion 签发时用户的 token 版本号，用户“退出所有设备”后版本号递增，旧版本的 token 全部失效
+	TokenVersion         int64 `json:"ver"`
+	jwt.RegisteredClaims       // 包含 iss, aud, exp, iat, jti 等标准 Claims
 }
 
-func CreateJWTToken(userID int64, username string) (string, error) {
-	// 设置 1 小时后过期
-	expirationTime := time.Now().Add(1 * time.Hour)
+// CreateAccessToken 签发 access token，每个 token 带有唯一的 jti，用于单独吊销
+func CreateAccessToken(userID int64, username string, tokenVersion int64) (string, error) {
+	if keys == nil {
+		return "", errors.New("jwt not initialized")
+	}
+
+	jti, err := security.RandomToken(16)
+	if err != nil {
+		return "", err
+	}
 
+	now := time.Now()
 	claims := UserClaims{
-		UserID:   userID,
-		Username: username,
+		UserID:       userID,
+		Username:     username,
+		TokenVersion: tokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime), // 过期时间
-			IssuedAt:  jwt.NewNumericDate(time.Now()),     // 签发时间
-			Issuer:    "sayit",                            // 签发人
+			ID:        jti,                                         // 唯一标识
+			ExpiresAt: jwt.NewNumericDate(now.Add(keys.accessTTL)), // 过期时间
+			IssuedAt:  jwt.NewNumericDate(now),                     // 签发时间
+			Issuer:    keys.issuer,                                 // 签发人
 		},
 	}
+	if keys.audience != "" {
+		claims.Audience = jwt.ClaimStrings{keys.audience}
+	}
 
-	// 使用 HS256 算法创建 Token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// 使用当前的签名密钥创建 Token，并在头部带上 kid，校验时据此选择密钥
+	token := jwt.NewWithClaims(keys.signing.method, claims)
+	token.Header["kid"] = keys.signing.id
 
 	// 使用密钥签名，得到完整的 Token 字符串
-	return token.SignedString(jwtSecret)
+	return token.SignedString(keys.signing.signKey)
 }
 
 func ParseJWTToken(tokenString string) (*UserClaims, error) {
+	if keys == nil {
+		return nil, errors.New("jwt not initialized")
+	}
+
 	var mc = new(UserClaims)
 
+	opts := []jwt.ParserOption{jwt.WithIssuer(keys.issuer)}
+	if keys.audience != "" {
+		opts = append(opts, jwt.WithAudience(keys.audience))
+	}
+
 	// V5 库的 ParseWithClaims 保持了相同的签名，但对内部类型处理更严格
 	token, err := jwt.ParseWithClaims(tokenString, mc, func(token *jwt.Token) (i any, err error) {
-		// 校验签名方法是否是 HS256
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		// 按 kid 选择密钥，没有 kid 的 token 使用当前的签名密钥校验
+		key := keys.signing
+		if kid, ok := token.Header["kid"].(string); ok {
+			if key, ok = keys.keys[kid]; !ok {
+				return nil, fmt.Errorf("unknown key id: %s", kid)
+			}
+		}
+
+		// 校验签名方法是否与密钥的算法一致，防止算法替换攻击
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return jwtSecret, nil
-	})
+		return key.verifyKey, nil
+	}, opts...)
 
 	// 验证错误
 	if err != nil {
@@ -1,19 +1,45 @@
 package jwt
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/namelyzz/sayit/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestCreateJWTToken(t *testing.T) {
+const testSecret = "Faiz555WuMingKe"
+
+var jwtSecret = []byte(testSecret)
+
+var testConfig = &config.JWTConfig{
+	Keys: []config.JWTKeyConfig{
+		{ID: "test", Algorithm: "HS256", Secret: testSecret},
+	},
+}
+
+func TestMain(m *testing.M) {
+	if err := Init(testConfig); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestCreateAccessToken(t *testing.T) {
 	userID := int64(123)
 	username := "testUser"
 
 	// 调用创建 Token 的方法
-	tokenString, err := CreateJWTToken(userID, username)
+	tokenString, err := CreateAccessToken(userID, username, 0)
 	// 确保没有错误
 	assert.NoError(t, err, "Error should be nil when generating token")
 	// 确保 tokenString 不为空
@@ -70,7 +96,7 @@ func TestParseJWTToken_ValidToken(t *testing.T) {
 	username := "testUser"
 
 	// 调用创建 Token 的方法
-	tokenString, err := CreateJWTToken(userID, username)
+	tokenString, err := CreateAccessToken(userID, username, 0)
 	assert.NoError(t, err, "Error should be nil when generating token")
 
 	// 调用解析有效 Token 的方法
@@ -108,3 +134,84 @@ func TestParseJWTToken_SignatureMethodMismatch(t *testing.T) {
 	assert.Error(t, err, "Parsing a token with signature method mismatch should return an error")
 	assert.Contains(t, err.Error(), "signature is invalid", "Error should indicate signature is invalid")
 }
+
+// writePEM 将密钥以 PEM 格式写入临时文件
+func writePEM(t *testing.T, name, typ string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func TestKeyRotation(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, Init(testConfig)) })
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaFile := writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edFile := writePEM(t, "ed.pem", "PRIVATE KEY", edDER)
+
+	keysCfg := []config.JWTKeyConfig{
+		{ID: "rsa-1", Algorithm: "RS256", PrivateKeyFile: rsaFile},
+		{ID: "ed-2", Algorithm: "EdDSA", PrivateKeyFile: edFile},
+	}
+
+	// 第一阶段：使用 RSA 密钥签发
+	require.NoError(t, Init(&config.JWTConfig{SigningKeyID: "rsa-1", Keys: keysCfg, Audience: "sayit-app"}))
+	oldToken, err := CreateAccessToken(123, "testUser", 0)
+	require.NoError(t, err)
+
+	// 第二阶段：切换到 Ed25519 密钥签发，旧 token 依然有效
+	require.NoError(t, Init(&config.JWTConfig{SigningKeyID: "ed-2", Keys: keysCfg, Audience: "sayit-app"}))
+	newToken, err := CreateAccessToken(456, "newUser", 0)
+	require.NoError(t, err)
+
+	claims, err := ParseJWTToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, int64(123), claims.UserID)
+
+	claims, err = ParseJWTToken(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, int64(456), claims.UserID)
+
+	// 第三阶段：移除旧密钥，旧 token 失效
+	require.NoError(t, Init(&config.JWTConfig{Keys: keysCfg[1:], Audience: "sayit-app"}))
+	_, err = ParseJWTToken(oldToken)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown key id")
+
+	// JWKS 中只包含公钥
+	set := JWKS()
+	require.Len(t, set.Keys, 1)
+	assert.Equal(t, "ed-2", set.Keys[0].Kid)
+	assert.Equal(t, "OKP", set.Keys[0].Kty)
+	assert.Equal(t, "EdDSA", set.Keys[0].Alg)
+}
+
+func TestParseJWTToken_AudienceMismatch(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, Init(testConfig)) })
+
+	require.NoError(t, Init(&config.JWTConfig{Keys: testConfig.Keys, Audience: "app-a"}))
+	tokenString, err := CreateAccessToken(123, "testUser", 0)
+	require.NoError(t, err)
+
+	require.NoError(t, Init(&config.JWTConfig{Keys: testConfig.Keys, Audience: "app-b"}))
+	_, err = ParseJWTToken(tokenString)
+	assert.Error(t, err)
+
+	// HS256 密钥不会出现在 JWKS 中
+	assert.Empty(t, JWKS().Keys)
+}
+
+func TestInit_NoKeys(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, Init(testConfig)) })
+
+	// 没有配置 keys 时启动失败，不会退回使用全局的 secret
+	assert.Error(t, Init(nil))
+	assert.Error(t, Init(&config.JWTConfig{}))
+}
@@ -0,0 +1,247 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/namelyzz/sayit/config"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	defaultIssuer     = "sayit"
+	defaultAccessTTL  = 1 * time.Hour
+	defaultRefreshTTL = 7 * 24 * time.Hour
+)
+
+// signingKey 一个可用于签名或校验的密钥
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   any // HS256 为 []byte，RS256 为 *rsa.PrivateKey，EdDSA 为 ed25519.PrivateKey；只用于校验的密钥为 nil
+	verifyKey any // HS256 为 []byte，RS256 为 *rsa.PublicKey，EdDSA 为 ed25519.PublicKey
+}
+
+// keySet 全部密钥及签发参数，由 Init 根据配置构建
+type keySet struct {
+	signing    *signingKey
+	keys       map[string]*signingKey
+	issuer     string
+	audience   string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+var keys *keySet
+
+/*
+Init 根据配置加载签名密钥
+
+支持 HS256、RS256、EdDSA 三种算法，每个密钥通过 kid 区分，签发的 token 头部会带上 kid，
+校验时按 kid 找到对应的密钥。轮换密钥的步骤：
+ 1. 在 keys 中加入新密钥，并把 signing_key_id 指向它，新 token 开始使用新密钥签发
+ 2. 旧密钥保留到它签发的 token 全部过期后再删除，期间已登录的用户不受影响
+
+必须配置 jwt.keys，没有密钥时启动失败。全局的 secret 同时用作密码的 pepper，不能再兼作签名密钥
+*/
+func Init(cfg *config.JWTConfig) (err error) {
+	if cfg == nil || len(cfg.Keys) == 0 {
+		return fmt.Errorf("jwt keys not configured")
+	}
+	keyConfigs := cfg.Keys
+
+	ks := &keySet{
+		keys:       make(map[string]*signingKey, len(keyConfigs)),
+		issuer:     defaultIssuer,
+		audience:   cfg.Audience,
+		accessTTL:  defaultAccessTTL,
+		refreshTTL: defaultRefreshTTL,
+	}
+	if cfg.Issuer != "" {
+		ks.issuer = cfg.Issuer
+	}
+	if cfg.AccessTTL > 0 {
+		ks.accessTTL = time.Duration(cfg.AccessTTL) * time.Second
+	}
+	if cfg.RefreshTTL > 0 {
+		ks.refreshTTL = time.Duration(cfg.RefreshTTL) * time.Second
+	}
+
+	for i := range keyConfigs {
+		k, err := loadKey(&keyConfigs[i])
+		if err != nil {
+			return fmt.Errorf("load jwt key %q failed: %w", keyConfigs[i].ID, err)
+		}
+		if _, ok := ks.keys[k.id]; ok {
+			return fmt.Errorf("duplicate jwt key id: %q", k.id)
+		}
+		ks.keys[k.id] = k
+	}
+
+	signingKeyID := cfg.SigningKeyID
+	if signingKeyID == "" {
+		signingKeyID = keyConfigs[0].ID
+	}
+	ks.signing = ks.keys[signingKeyID]
+	if ks.signing == nil {
+		return fmt.Errorf("signing key %q not found", signingKeyID)
+	}
+	if ks.signing.signKey == nil {
+		return fmt.Errorf("signing key %q has no private key", signingKeyID)
+	}
+
+	keys = ks
+	return nil
+}
+
+func loadKey(cfg *config.JWTKeyConfig) (k *signingKey, err error) {
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("key id is required")
+	}
+	k = &signingKey{id: cfg.ID}
+
+	switch cfg.Algorithm {
+	case "HS256", "":
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("secret is required for HS256")
+		}
+		k.method = jwt.SigningMethodHS256
+		k.signKey = []byte(cfg.Secret)
+		k.verifyKey = []byte(cfg.Secret)
+
+	case "RS256":
+		k.method = jwt.SigningMethodRS256
+		if cfg.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			k.signKey = priv
+			k.verifyKey = &priv.PublicKey
+		}
+		if cfg.PublicKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if k.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+
+	case "EdDSA":
+		k.method = jwt.SigningMethodEdDSA
+		if cfg.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			k.signKey = priv
+			k.verifyKey = priv.(ed25519.PrivateKey).Public()
+		}
+		if cfg.PublicKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if k.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", cfg.Algorithm)
+	}
+
+	if k.verifyKey == nil {
+		return nil, fmt.Errorf("private_key_file or public_key_file is required for %s", cfg.Algorithm)
+	}
+	return k, nil
+}
+
+// AccessTokenTTL access token 的有效期
+func AccessTokenTTL() time.Duration {
+	if keys == nil {
+		return defaultAccessTTL
+	}
+	return keys.accessTTL
+}
+
+// RefreshTokenTTL refresh token 的有效期
+func RefreshTokenTTL() time.Duration {
+	if keys == nil {
+		return defaultRefreshTTL
+	}
+	return keys.refreshTTL
+}
+
+// JWK 单个公钥，格式见 RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet JWKS 接口的响应
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS 导出全部非对称密钥的公钥，供其他服务校验 token；HS256 的密钥是对称的，不能公开
+func JWKS() *JWKSet {
+	set := &JWKSet{Keys: []JWK{}}
+	if keys == nil {
+		return set
+	}
+
+	ids := make([]string, 0, len(keys.keys))
+	for id := range keys.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		k := keys.keys[id]
+		switch pub := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: k.id,
+				Use: "sig",
+				Alg: k.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: k.id,
+				Use: "sig",
+				Alg: k.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return set
+}
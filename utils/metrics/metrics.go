@@ -0,0 +1,93 @@
+/*
+Package metrics 定义服务对外暴露的 Prometheus 指标，通过 /metrics 采集
+
+标签的取值必须是有限的：HTTP 使用路由模板（如 /api/v1/post/:id）而不是实际路径，
+数据库使用表名，Redis 使用命令名，业务指标只使用枚举值
+*/
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "sayit"
+
+// 状态标签的取值
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// HTTP 请求
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP 请求数，route 为路由模板，未匹配到路由时为 unmatched",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP 请求处理耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// 存储
+var (
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "GORM 执行 SQL 的耗时，operation 为 create/query/update/delete/row/raw",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table", "status"})
+
+	RedisCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Redis 命令的耗时，pipeline 整体记为一次 pipeline 命令",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5},
+	}, []string{"command", "status"})
+)
+
+// 业务事件
+var (
+	PostsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "posts_created_total",
+		Help:      "发帖成功的次数",
+	})
+
+	VotesCast = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "votes_cast_total",
+		Help:      "投票成功的次数，direction 为 up/down/cancel",
+	}, []string{"direction"})
+
+	Logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "登录次数，result 为 success/failure/blocked",
+	}, []string{"result"})
+)
+
+// 登录结果
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	LoginBlocked = "blocked"
+)
+
+// VoteDirection 投票方向对应的标签值
+func VoteDirection(direction int8) string {
+	switch {
+	case direction > 0:
+		return "up"
+	case direction < 0:
+		return "down"
+	default:
+		return "cancel"
+	}
+}
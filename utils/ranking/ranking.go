@@ -0,0 +1,171 @@
+package ranking
+
+import (
+	"fmt"
+	"github.com/namelyzz/sayit/config"
+	"github.com/namelyzz/sayit/models"
+	"math"
+)
+
+const (
+	// hotEpoch 计算 hot 分数时的时间起点，取自 Reddit 的实现，只影响分数的绝对值，不影响排序
+	hotEpoch = 1134028003
+
+	defaultHotDecay       = 45000
+	defaultBestConfidence = 1.281551565545 // 80% 置信度对应的 z 值
+)
+
+/*
+Strategy 排序策略：根据帖子的赞成票数、反对票数和发帖时间计算它在排行榜中的分数
+
+每个策略在 Redis 中对应一个 post:rank:<name> 排行榜，发帖和投票时由 service 计算分数并写入；
+MySQL 中不保存这些分数，复杂查询需要按策略排序时使用 SQL 表达式现场计算。
+分数相同的帖子在两条路径上都按帖子 ID 排序，雪花 ID 随时间递增，相当于按发帖时间排序，
+best、controversial 中大量没有投票的帖子分数都是 0，它们之间按新旧排列，分页时也不需要取出整组同分的帖子
+*/
+type Strategy interface {
+	// Name 策略名称，同时也是 ParamPostList.SortBy 的取值
+	Name() models.SortField
+	// Version 公式及其参数的版本，与 Redis 中记录的版本不一致时需要重建排行榜
+	Version() string
+	// Score 计算分数，createTime 为发帖时间的 Unix 秒
+	Score(up, down, createTime int64) float64
+	// SQL 返回计算分数的 SQL 表达式，参数为赞成票数、反对票数和发帖时间的列名
+	SQL(up, down, createTime string) string
+}
+
+var strategies = newStrategies(nil)
+
+// Init 根据配置创建全部排序策略，参数的调整会体现在 Version 中，从而触发排行榜重建
+func Init(cfg *config.RankingConfig) {
+	strategies = newStrategies(cfg)
+}
+
+func newStrategies(cfg *config.RankingConfig) map[models.SortField]Strategy {
+	h := hot{decay: defaultHotDecay}
+	b := best{z: defaultBestConfidence}
+	if cfg != nil {
+		if cfg.HotDecay > 0 {
+			h.decay = cfg.HotDecay
+		}
+		if cfg.BestConfidence > 0 {
+			b.z = cfg.BestConfidence
+		}
+	}
+
+	return map[models.SortField]Strategy{
+		models.SortFieldHot:           h,
+		models.SortFieldBest:          b,
+		models.SortFieldControversial: controversial{},
+	}
+}
+
+// Get 获取排序字段对应的策略，create_time 等普通排序字段返回 false
+func Get(sortBy models.SortField) (Strategy, bool) {
+	s, ok := strategies[sortBy]
+	return s, ok
+}
+
+// Scores 计算帖子在每个策略下的分数
+func Scores(up, down, createTime int64) map[models.SortField]float64 {
+	res := make(map[models.SortField]float64, len(strategies))
+	for name, s := range strategies {
+		res[name] = s.Score(up, down, createTime)
+	}
+	return res
+}
+
+/*
+hot Reddit 的 hot 排序：分数 = sign(s) * log10(max(|s|, 1)) + (t - epoch) / decay，其中 s 为净票数
+
+票数取对数，前 10 票与之后的 100 票作用相同；时间项线性增长，新帖子天然排在前面。
+decay 秒的时间差相当于净票数相差 10 倍，调小 decay 旧帖子沉得更快
+*/
+type hot struct {
+	decay float64
+}
+
+func (h hot) Name() models.SortField { return models.SortFieldHot }
+
+func (h hot) Version() string { return fmt.Sprintf("hot:v1:decay=%g", h.decay) }
+
+func (h hot) Score(up, down, createTime int64) float64 {
+	s := float64(up - down)
+	order := math.Log10(math.Max(math.Abs(s), 1))
+
+	var sign float64
+	switch {
+	case s > 0:
+		sign = 1
+	case s < 0:
+		sign = -1
+	}
+	return sign*order + float64(createTime-hotEpoch)/h.decay
+}
+
+func (h hot) SQL(up, down, createTime string) string {
+	return fmt.Sprintf("(SIGN(%[1]s - %[2]s) * LOG10(GREATEST(ABS(%[1]s - %[2]s), 1)) + (UNIX_TIMESTAMP(%[3]s) - %[4]d) / %[5]g)",
+		up, down, createTime, hotEpoch, h.decay)
+}
+
+/*
+best Wilson 置信区间下界：在给定置信度下，赞成率至少有多高
+
+只看赞成率时 1 赞 0 踩的帖子会排在 99 赞 1 踩之前，下界会因票数少而变低，票数越多越接近真实赞成率。
+与时间无关，适合评论、问答之类“最好的排前面”的场景
+*/
+type best struct {
+	z float64
+}
+
+func (b best) Name() models.SortField { return models.SortFieldBest }
+
+func (b best) Version() string { return fmt.Sprintf("best:v1:z=%g", b.z) }
+
+func (b best) Score(up, down, createTime int64) float64 {
+	n := float64(up + down)
+	if n == 0 {
+		return 0
+	}
+
+	p := float64(up) / n
+	z2 := b.z * b.z
+	return (p + z2/(2*n) - b.z*math.Sqrt((p*(1-p)+z2/(4*n))/n)) / (1 + z2/n)
+}
+
+func (b best) SQL(up, down, createTime string) string {
+	n := fmt.Sprintf("(%s + %s)", up, down)
+	p := fmt.Sprintf("(%s / %s)", up, n)
+	return fmt.Sprintf("(CASE WHEN %[1]s = 0 THEN 0 ELSE "+
+		"(%[2]s + %[3]g / (2 * %[1]s) - %[4]g * SQRT((%[2]s * (1 - %[2]s) + %[3]g / (4 * %[1]s)) / %[1]s)) / (1 + %[3]g / %[1]s) END)",
+		n, p, b.z*b.z, b.z)
+}
+
+/*
+controversial Reddit 的 controversial 排序：分数 = (up + down) ^ (少数票 / 多数票)
+
+赞成和反对越接近、总票数越多，分数越高；一边倒或者没有反对票的帖子分数为 0
+*/
+type controversial struct{}
+
+func (c controversial) Name() models.SortField { return models.SortFieldControversial }
+
+func (c controversial) Version() string { return "controversial:v1" }
+
+func (c controversial) Score(up, down, createTime int64) float64 {
+	if up <= 0 || down <= 0 {
+		return 0
+	}
+
+	magnitude := float64(up + down)
+	balance := float64(down) / float64(up)
+	if up < down {
+		balance = float64(up) / float64(down)
+	}
+	return math.Pow(magnitude, balance)
+}
+
+func (c controversial) SQL(up, down, createTime string) string {
+	return fmt.Sprintf("(CASE WHEN %[1]s <= 0 OR %[2]s <= 0 THEN 0 ELSE POW(%[1]s + %[2]s, LEAST(%[1]s, %[2]s) / GREATEST(%[1]s, %[2]s)) END)",
+		up, down)
+}
@@ -0,0 +1,59 @@
+package ranking
+
+import (
+	"testing"
+
+	"github.com/namelyzz/sayit/config"
+	"github.com/namelyzz/sayit/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHot(t *testing.T) {
+	s, ok := Get(models.SortFieldHot)
+	require.True(t, ok)
+
+	now := int64(1700000000)
+	// 同一时间发布，净票数多的排前面
+	assert.Greater(t, s.Score(100, 0, now), s.Score(10, 0, now))
+	// 净票数相同时，新帖子排前面
+	assert.Greater(t, s.Score(10, 0, now+1), s.Score(10, 0, now))
+	// 净票数相差 10 倍，相当于晚发布 decay 秒
+	assert.InDelta(t, s.Score(10, 0, now+defaultHotDecay), s.Score(100, 0, now), 1e-9)
+	// 净票数为负时向下调整
+	assert.Less(t, s.Score(0, 10, now), s.Score(0, 0, now))
+}
+
+func TestBest(t *testing.T) {
+	s, ok := Get(models.SortFieldBest)
+	require.True(t, ok)
+
+	assert.Equal(t, float64(0), s.Score(0, 0, 0))
+	// 票数多、赞成率高的排在票数少的前面
+	assert.Greater(t, s.Score(99, 1, 0), s.Score(1, 0, 0))
+	// 结果是赞成率的下界
+	assert.Less(t, s.Score(99, 1, 0), 0.99)
+}
+
+func TestControversial(t *testing.T) {
+	s, ok := Get(models.SortFieldControversial)
+	require.True(t, ok)
+
+	assert.Equal(t, float64(0), s.Score(10, 0, 0))
+	assert.Equal(t, float64(0), s.Score(0, 10, 0))
+	// 票数接近的排在一边倒的前面
+	assert.Greater(t, s.Score(50, 50, 0), s.Score(90, 10, 0))
+	// 正反票数对调，分数不变
+	assert.Equal(t, s.Score(90, 10, 0), s.Score(10, 90, 0))
+}
+
+func TestInit_VersionChanges(t *testing.T) {
+	t.Cleanup(func() { Init(nil) })
+
+	s, _ := Get(models.SortFieldHot)
+	before := s.Version()
+
+	Init(&config.RankingConfig{HotDecay: 12 * 3600})
+	s, _ = Get(models.SortFieldHot)
+	assert.NotEqual(t, before, s.Version())
+}
@@ -0,0 +1,131 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// 命中的词在结果中的标记
+const (
+	PreTag  = "<em>"
+	PostTag = "</em>"
+
+	ellipsis = "..."
+)
+
+// Terms 把搜索词按空白拆分为待高亮的词，统一转为小写并去重
+func Terms(q string) []string {
+	fields := strings.Fields(strings.ToLower(q))
+	terms := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if !seen[f] {
+			seen[f] = true
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+// Highlight 转义 text 中的 HTML，并用 PreTag、PostTag 包裹命中的词，不区分大小写
+func Highlight(text string, terms []string) string {
+	runes := []rune(text)
+	return render(runes, matchRanges(runes, terms), 0, len(runes))
+}
+
+/*
+Snippet 从 text 中截取 size 个字符的片段并高亮命中的词
+
+片段从第一个命中的词之前一小段开始，让命中的词带上前文；没有命中时从头截取。
+片段前后被截断时加上省略号
+*/
+func Snippet(text string, terms []string, size int) string {
+	runes := []rune(text)
+	ranges := matchRanges(runes, terms)
+	if len(runes) <= size {
+		return render(runes, ranges, 0, len(runes))
+	}
+
+	start := 0
+	if len(ranges) > 0 {
+		start = max(ranges[0][0]-size/4, 0)
+	}
+	end := min(start+size, len(runes))
+	start = max(end-size, 0)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString(ellipsis)
+	}
+	b.WriteString(render(runes, ranges, start, end))
+	if end < len(runes) {
+		b.WriteString(ellipsis)
+	}
+	return b.String()
+}
+
+// matchRanges 找出所有命中的区间 [start, end)，按起点排序并合并重叠或相邻的区间
+func matchRanges(runes []rune, terms []string) [][2]int {
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var ranges [][2]int
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if hasPrefix(lower[i:], t) {
+				ranges = append(ranges, [2]int{i, i + len(t)})
+			}
+		}
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			last[1] = max(last[1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func hasPrefix(s, prefix []rune) bool {
+	for i, r := range prefix {
+		if s[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// render 输出 runes[from:to]，命中区间被截断时只标记落在范围内的部分
+func render(runes []rune, ranges [][2]int, from, to int) string {
+	var b strings.Builder
+	pos := from
+	for _, r := range ranges {
+		start, end := max(r[0], from), min(r[1], to)
+		if start >= end {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:start])))
+		b.WriteString(PreTag)
+		b.WriteString(html.EscapeString(string(runes[start:end])))
+		b.WriteString(PostTag)
+		pos = end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTerms(t *testing.T) {
+	assert.Equal(t, []string{"golang", "并发"}, Terms("  Golang 并发 golang "))
+	assert.Empty(t, Terms("   "))
+}
+
+func TestHighlight(t *testing.T) {
+	terms := Terms("Go 并发")
+	assert.Equal(t, "<em>go</em> 语言的<em>并发</em>模型", Highlight("go 语言的并发模型", terms))
+	// 不区分大小写，保留原文的大小写
+	assert.Equal(t, "学习 <em>GO</em>", Highlight("学习 GO", terms))
+	// 原文中的 HTML 会被转义
+	assert.Equal(t, "&lt;b&gt;<em>并发</em>&lt;/b&gt;", Highlight("<b>并发</b>", terms))
+	// 重叠的命中合并为一处
+	assert.Equal(t, "<em>数据库</em>", Highlight("数据库", []string{"数据", "据库"}))
+}
+
+func TestSnippet(t *testing.T) {
+	terms := []string{"命中"}
+
+	assert.Equal(t, "短文<em>命中</em>", Snippet("短文命中", terms, 10))
+	// 没有命中时从头截取
+	assert.Equal(t, "一二三四五...", Snippet("一二三四五六七八九十", []string{"无"}, 5))
+	// 命中位置之前保留 size/4 个字符
+	assert.Equal(t, "...六七<em>命中</em>八九十甲乙丙丁...",
+		Snippet("一二三四五六七命中八九十甲乙丙丁戊己庚", terms, 11))
+	// 命中位置靠近结尾时，片段向前补足长度
+	assert.Equal(t, "...四五六七八<em>命中</em>", Snippet("一二三四五六七八命中", terms, 7))
+}
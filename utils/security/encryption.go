@@ -1,22 +1,176 @@
 package security
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"fmt"
 	"github.com/namelyzz/sayit/config"
+	"golang.org/x/crypto/argon2"
+	"strings"
 )
 
-// HashPassword 使用 SHA256 对密码进行加密，使用 salt（来自配置文件）来增加复杂性
-func HashPassword(password string) string {
+// argon2id 的默认参数，参考 RFC 9106 中内存受限环境下的推荐值
+const (
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 4
+	defaultArgon2KeyLen  = 32
+	defaultArgon2SaltLen = 16
+)
+
+// 解析已保存的哈希时允许的参数范围。哈希串来自数据库，参数不可信：
+// 盐或哈希过短时校验形同虚设，p=0 会让 argon2 panic，过大的 m、t 会让每次登录都耗尽内存和 CPU
+const (
+	minArgon2SaltLen = 16
+	minArgon2KeyLen  = 16
+	maxArgon2Time    = 16
+	maxArgon2Memory  = 1024 * 1024 // 1 GiB
+)
+
+const argon2idPrefix = "$argon2id$"
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	keyLen  uint32
+	saltLen uint32
+}
+
+// currentParams 读取配置中的哈希参数，未配置的项使用默认值
+func currentParams() argon2Params {
+	p := argon2Params{
+		time:    defaultArgon2Time,
+		memory:  defaultArgon2Memory,
+		threads: defaultArgon2Threads,
+		keyLen:  defaultArgon2KeyLen,
+		saltLen: defaultArgon2SaltLen,
+	}
+
+	cfg := config.Conf.PasswordConfig
+	if cfg == nil {
+		return p
+	}
+	if cfg.Time > 0 {
+		p.time = cfg.Time
+	}
+	if cfg.Memory > 0 {
+		p.memory = cfg.Memory
+	}
+	if cfg.Threads > 0 {
+		p.threads = cfg.Threads
+	}
+	if cfg.KeyLen > 0 {
+		p.keyLen = cfg.KeyLen
+	}
+	if cfg.SaltLen > 0 {
+		p.saltLen = cfg.SaltLen
+	}
+	return p
+}
+
+/*
+HashPassword 使用 argon2id 对密码进行哈希，每个密码使用独立的随机盐
+
+结果为 PHC 字符串格式，参数和盐都保存在哈希串中，调整参数后旧的哈希仍然可以校验：
+
+	$argon2id$v=19$m=65536,t=3,p=4$<base64 盐>$<base64 哈希>
+*/
+func HashPassword(password string) (string, error) {
+	p := currentParams()
+
+	salt := make([]byte, p.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// VerifyPassword 用来验证用户输入的密码是否正确，同时兼容旧版的 SHA256 哈希
+// 比较哈希结果时使用常量时间比较，避免通过响应时间推测哈希内容
+func VerifyPassword(inputPassword, storedHash string) bool {
+	if !strings.HasPrefix(storedHash, argon2idPrefix) {
+		hashedInput := legacyHashPassword(inputPassword)
+		return subtle.ConstantTimeCompare([]byte(hashedInput), []byte(storedHash)) == 1
+	}
+
+	p, salt, hash, err := decodeArgon2Hash(storedHash)
+	if err != nil {
+		return false
+	}
+
+	hashedInput := argon2.IDKey([]byte(inputPassword), salt, p.time, p.memory, p.threads, p.keyLen)
+	return subtle.ConstantTimeCompare(hashedInput, hash) == 1
+}
+
+// NeedsRehash 判断已保存的哈希是否需要用当前参数重新计算：旧版 SHA256 哈希，或者 argon2id 参数已调整
+func NeedsRehash(storedHash string) bool {
+	if !strings.HasPrefix(storedHash, argon2idPrefix) {
+		return true
+	}
+
+	p, salt, hash, err := decodeArgon2Hash(storedHash)
+	if err != nil {
+		return true
+	}
+
+	cur := currentParams()
+	return p.time != cur.time || p.memory != cur.memory || p.threads != cur.threads ||
+		uint32(len(salt)) != cur.saltLen || uint32(len(hash)) != cur.keyLen
+}
+
+// decodeArgon2Hash 解析 PHC 格式的 argon2id 哈希串
+func decodeArgon2Hash(encoded string) (p argon2Params, salt, hash []byte, err error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=4", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, fmt.Errorf("invalid argon2id hash format")
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, err
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version: %d", version)
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, err
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, err
+	}
+	if hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, err
+	}
+	p.saltLen = uint32(len(salt))
+	p.keyLen = uint32(len(hash))
+
+	if p.time == 0 || p.time > maxArgon2Time || p.memory == 0 || p.memory > maxArgon2Memory || p.threads == 0 {
+		return p, nil, nil, fmt.Errorf("invalid argon2id params: m=%d,t=%d,p=%d", p.memory, p.time, p.threads)
+	}
+	if p.saltLen < minArgon2SaltLen || p.keyLen < minArgon2KeyLen {
+		return p, nil, nil, fmt.Errorf("argon2id salt or hash too short")
+	}
+	return p, salt, hash, nil
+}
+
+// legacyHashPassword 旧版的密码哈希：SHA256(密码 + 全局 secret)，仅用于校验尚未迁移的旧密码
+func legacyHashPassword(password string) string {
 	str := password + config.Conf.Secret
 	hash := sha256.New()
 	hash.Write([]byte(str))
 	return hex.EncodeToString(hash.Sum(nil))
 }
-
-// VerifyPassword 用来验证用户输入的密码是否正确
-func VerifyPassword(inputPassword, storedHash string) bool {
-	// 用相同的 salt 和 hash 方法加密输入的密码
-	hashedInput := HashPassword(inputPassword)
-	return hashedInput == storedHash
-}
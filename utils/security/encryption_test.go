@@ -0,0 +1,74 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/namelyzz/sayit/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 测试中使用较小的参数，避免哈希耗时过长
+func setupPasswordConfig(t *testing.T, cfg *config.PasswordConfig) {
+	old := config.Conf.PasswordConfig
+	config.Conf.PasswordConfig = cfg
+	t.Cleanup(func() { config.Conf.PasswordConfig = old })
+}
+
+func TestHashPassword(t *testing.T) {
+	setupPasswordConfig(t, &config.PasswordConfig{Time: 1, Memory: 1024, Threads: 1})
+
+	hashed, err := HashPassword("faiz555")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hashed, "$argon2id$v=19$m=1024,t=1,p=1$"), hashed)
+
+	// 每次哈希使用不同的盐
+	hashed2, err := HashPassword("faiz555")
+	require.NoError(t, err)
+	assert.NotEqual(t, hashed, hashed2)
+
+	assert.True(t, VerifyPassword("faiz555", hashed))
+	assert.True(t, VerifyPassword("faiz555", hashed2))
+	assert.False(t, VerifyPassword("wrong", hashed))
+	assert.False(t, NeedsRehash(hashed))
+}
+
+func TestVerifyPassword_Legacy(t *testing.T) {
+	setupPasswordConfig(t, &config.PasswordConfig{Time: 1, Memory: 1024, Threads: 1})
+
+	legacy := legacyHashPassword("faiz555")
+	assert.True(t, VerifyPassword("faiz555", legacy))
+	assert.False(t, VerifyPassword("wrong", legacy))
+
+	// 旧版哈希需要迁移
+	assert.True(t, NeedsRehash(legacy))
+}
+
+func TestNeedsRehash_ParamsChanged(t *testing.T) {
+	setupPasswordConfig(t, &config.PasswordConfig{Time: 1, Memory: 1024, Threads: 1})
+	hashed, err := HashPassword("faiz555")
+	require.NoError(t, err)
+
+	// 调整参数后，旧参数的哈希仍然可以校验，但需要重新哈希
+	setupPasswordConfig(t, &config.PasswordConfig{Time: 2, Memory: 1024, Threads: 1})
+	assert.True(t, VerifyPassword("faiz555", hashed))
+	assert.True(t, NeedsRehash(hashed))
+}
+
+func TestVerifyPassword_Malformed(t *testing.T) {
+	assert.False(t, VerifyPassword("faiz555", "$argon2id$v=19$broken"))
+	assert.True(t, NeedsRehash("$argon2id$v=19$broken"))
+
+	salt := "$c29tZXNhbHRzb21lc2FsdA"
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=65536,t=3,p=4" + salt + "$",                       // 哈希为空，任意密码都会通过
+		"$argon2id$v=19$m=65536,t=3,p=0" + salt + "$c29tZWhhc2hzb21laGFzaA", // argon2 会 panic
+		"$argon2id$v=19$m=4294967295,t=3,p=4" + salt + "$c29tZWhhc2hzb21laGFzaA",
+		"$argon2id$v=19$m=65536,t=100000,p=4" + salt + "$c29tZWhhc2hzb21laGFzaA",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$c29tZWhhc2hzb21laGFzaA",
+	} {
+		assert.False(t, VerifyPassword("faiz555", encoded), encoded)
+		assert.True(t, NeedsRehash(encoded), encoded)
+	}
+}
@@ -0,0 +1,16 @@
+package security
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RandomToken 生成 n 字节的密码学安全随机数，以十六进制字符串返回
+// 用于 refresh token、JWT 的 jti 等不可预测的标识
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}